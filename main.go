@@ -1,85 +1,979 @@
 package main
 
 import (
+	"bytes"
+	"cmp"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"os"
 	"pathfinder/pathfinder"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 )
 
 const (
-	red   = "\033[31m"
-	green = "\033[32m"
+	red    = "\033[31m"
+	green  = "\033[32m"
 	yellow = "\033[33m"
-	reset = "\033[0m"
+	reset  = "\033[0m"
 )
 
+// stdout and stderr drop the colours once --no-color or the NO_COLOR
+// environment variable turns them off, so write coloured text through them.
+var (
+	stdout io.Writer = os.Stdout
+	stderr io.Writer = os.Stderr
+)
+
+// command is a subcommand: go run . <name> [flags] [args].
+type command struct {
+	usage   string
+	summary string
+	run     func(args []string)
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"route":    {"route [flags] [map file] [start] [end] [trains]", "find paths and move trains along them", routeCommand},
+		"generate": {"generate [flags] [map file]", "generate a random map", generateCommand},
+		"scenario": {"scenario [flags] [map file]", "generate a map with a known optimum", generateScenario},
+		"validate": {"validate [flags] [map file]...", "report every problem in map files, or in a schedule", validateCommand},
+		"fmt":      {"fmt [flags] [map file]...", "rewrite map files in canonical form", formatMaps},
+		"stats":    {"stats [flags] [map file]...", "print the size and shape of map files", statsCommand},
+		"help":     {"help [command]", "show help for a command", helpCommand},
+	}
+}
+
 func main() {
-	for i := range os.Args {
-		if os.Args[i] == "-h" || os.Args[i] == "--help" {
+	if os.Getenv("NO_COLOR") != "" {
+		disableColor()
+	}
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			cmd.run(os.Args[2:])
+			return
+		}
+	}
+	legacy(os.Args[1:])
+}
+
+// ---- Subcommands ----
+
+// newFlagSet returns the flags of a subcommand, with --no-color and a usage
+// line built from its entry in commands.
+func newFlagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(os.Stdout)
+	flags.BoolFunc("no-color", "print without colours", func(string) error {
+		disableColor()
+		return nil
+	})
+	flags.Usage = func() {
+		cmd := commands[name]
+		fmt.Printf("To %s, use: go run . %s\n", cmd.summary, cmd.usage)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// usageError reports a misused subcommand with its help and exit status 2.
+func usageError(flags *flag.FlagSet, msg string) {
+	if jsonOutput {
+		pathfinder.WriteErrorsJSON(os.Stdout, []pathfinder.ErrorReport{{Code: "usage", Message: msg}})
+		os.Exit(2)
+	}
+	fmt.Fprintln(stderr, red+"Error: "+reset, yellow+msg+reset)
+	flags.Usage()
+	os.Exit(2)
+}
+
+// parseArgs is flags.Parse, except that flags may also follow the other
+// arguments, which it returns: route London.map waterloo st_pancras 4 -no-color.
+// After "--" every argument is kept as it is, and a negative number such as
+// -1 is an argument unless it is the value of a flag.
+func parseArgs(flags *flag.FlagSet, args []string) []string {
+	all := args
+	var rest []string
+	for len(args) > 0 {
+		// flags.Parse would take a negative number for a flag, so only
+		// parse up to it
+		end := 0
+		for end < len(args) && args[end] != "--" && !(isNegative(args[end]) && !takesValue(flags, args[:end])) {
+			end++
+		}
+		left := parseSome(flags, all, args[:end])
+		for len(left) > 0 {
+			rest = append(rest, left[0])
+			left = parseSome(flags, all, left[1:])
+		}
+		switch {
+		case end == len(args):
+			return rest
+		case args[end] == "--":
+			return append(rest, args[end+1:]...)
+		}
+		rest = append(rest, args[end])
+		args = args[end+1:]
+	}
+	return rest
+}
+
+// parseSome parses flags up to the first other argument and returns the
+// arguments left. Errors, and -help, go through usageError and Usage, so
+// they are JSON when the output is; all is every argument, to find out.
+func parseSome(flags *flag.FlagSet, all, args []string) []string {
+	usage, output := flags.Usage, flags.Output()
+	flags.Usage = func() {}
+	flags.SetOutput(io.Discard)
+	err := flags.Parse(args)
+	flags.Usage = usage
+	flags.SetOutput(output)
+	switch {
+	case errors.Is(err, flag.ErrHelp):
+		flags.Usage()
+		os.Exit(0)
+	case err != nil:
+		// The output flag may come after the one that failed
+		jsonOutput = jsonOutput || asksForJSON(all)
+		usageError(flags, err.Error())
+	}
+	return flags.Args()
+}
+
+// isNegative reports whether arg is a negative integer, such as -1.
+func isNegative(arg string) bool {
+	_, err := strconv.Atoi(arg)
+	return err == nil && strings.HasPrefix(arg, "-")
+}
+
+// takesValue reports whether the last of args is a flag waiting for its
+// value, as -k-shortest is in -k-shortest -1.
+func takesValue(flags *flag.FlagSet, args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+	last := args[len(args)-1]
+	name := strings.TrimLeft(last, "-")
+	if !strings.HasPrefix(last, "-") || strings.Contains(name, "=") {
+		return false
+	}
+	f := flags.Lookup(name)
+	if f == nil {
+		return false
+	}
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return !ok || !b.IsBoolFlag()
+}
+
+// asksForJSON reports whether args set -output or -format to json or ndjson.
+func asksForJSON(args []string) bool {
+	for i, arg := range args {
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if !strings.HasPrefix(arg, "-") || name != "output" && name != "format" {
+			continue
+		}
+		if !hasValue && i+1 < len(args) {
+			value = args[i+1]
+		}
+		if value == outputJSON || value == outputNDJSON {
+			return true
+		}
+	}
+	return false
+}
+
+// parseFlags is parseArgs followed by the positional arguments, which may
+// stand in for flags left unset: route London.map waterloo st_pancras 4.
+func parseFlags(flags *flag.FlagSet, args []string, positional ...*string) {
+	rest := parseArgs(flags, args)
+	if len(rest) > len(positional) {
+		usageError(flags, fmt.Sprintf("Unexpected argument %q", rest[len(positional)]))
+	}
+	for i, arg := range rest {
+		if *positional[i] != "" {
+			usageError(flags, fmt.Sprintf("Argument %q repeats a flag", arg))
+		}
+		*positional[i] = arg
+	}
+}
+
+// mapFlags adds the flags of every command that reads maps.
+func mapFlags(flags *flag.FlagSet) (maxErrors *int, limits *pathfinder.ParseOptions) {
+	maxErrors = flags.Int("max-errors", 10, "report at most N problems in a map file, 0 for all")
+	limits = &pathfinder.ParseOptions{}
+	flags.Func("max-stations", "accept maps with at most N stations (default 10000, 0 for no limit)", func(s string) error {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return fmt.Errorf("must be a non-negative integer")
+		}
+		limits.MaxStations = n
+		if n == 0 {
+			limits.MaxStations = pathfinder.NoLimit
+		}
+		return nil
+	})
+	return maxErrors, limits
+}
+
+// routeOptions is everything route needs, from flags or the legacy form.
+type routeOptions struct {
+	mapFile, start, end string
+	trains              int
+	maxErrors           int
+	limits              pathfinder.ParseOptions
+	policy              *pathfinder.ConflictPolicy
+	geometric           bool
+	kShortest           int
+	disjoint            pathfinder.Disjointness
+	output              string
+}
+
+// Output formats of route.
+const (
+	outputText   = "text"   // paths, predicted turns and the schedule, coloured
+	outputTurns  = "turns"  // the schedule alone, without colours
+	outputJSON   = "json"   // one pathfinder.RouteReport
+	outputNDJSON = "ndjson" // the same report, one record per line
+)
+
+// jsonOutput is set by -output=json or ndjson: errors are then written to
+// stdout as pathfinder.WriteErrorsJSON documents instead of coloured text.
+var jsonOutput bool
+
+// setOutput checks an output format and turns on JSON errors for it.
+func setOutput(output string) error {
+	switch output {
+	case outputJSON, outputNDJSON:
+		jsonOutput = true
+	case outputText, outputTurns:
+	default:
+		return fmt.Errorf("Unknown output format %q. Use text, turns, json or ndjson", output)
+	}
+	return nil
+}
+
+func routeCommand(args []string) {
+	flags := newFlagSet("route")
+	opts := routeOptions{}
+	flags.StringVar(&opts.mapFile, "map", "", "map file")
+	flags.StringVar(&opts.start, "start", "", "start station")
+	flags.StringVar(&opts.end, "end", "", "end station")
+	flags.IntVar(&opts.trains, "trains", 0, "number of trains")
+	maxErrors, limits := mapFlags(flags)
+	flags.BoolVar(&opts.geometric, "geometric", false, "list the paths by straight-line length and print their lengths")
+	flags.Func("disjoint", "what paths may not share: station (default), edge or none", func(s string) (err error) {
+		opts.disjoint, err = pathfinder.ParseDisjointness(s)
+		return err
+	})
+	flags.IntVar(&opts.kShortest, "k-shortest", 0, "list the K shortest paths, which may share stations, and run the trains over them")
+	flags.Func("conflicts", "track rule: edge (default), directional, block or block:N, and list held trains", func(s string) error {
+		p, err := pathfinder.ParseConflictPolicy(s)
+		opts.policy = &p
+		return err
+	})
+	// Set as soon as they are parsed, so later errors are JSON too
+	opts.output = outputText
+	setFormat := func(s string) error {
+		opts.output = s
+		return setOutput(s)
+	}
+	flags.Func("output", "output format: text (default), turns (the schedule alone, without colours), json or ndjson", setFormat)
+	flags.Func("format", "same as -output", setFormat)
+	var trains string
+	parseFlags(flags, args, &opts.mapFile, &opts.start, &opts.end, &trains)
+
+	if trains != "" && isSet(flags, "trains") {
+		usageError(flags, fmt.Sprintf("Argument %q repeats a flag", trains))
+	}
+	if opts.mapFile == "" || opts.start == "" || opts.end == "" || trains == "" && !isSet(flags, "trains") {
+		usageError(flags, "A map file, start and end stations and a number of trains are needed.")
+	}
+	if opts.kShortest < 0 {
+		usageError(flags, "-k-shortest must be a positive integer")
+	}
+	if trains != "" {
+		opts.trains = parseTrains(trains)
+	}
+	checkTrains(opts.trains)
+	opts.maxErrors, opts.limits = *maxErrors, *limits
+	route(opts)
+}
+
+func generateCommand(args []string) {
+	flags := newFlagSet("generate")
+	var mapFile string
+	flags.StringVar(&mapFile, "map", "", "map file to write, .map is added if missing")
+	names := flags.String("names", "", "text file of station names, one per line (default made-up names)")
+	stations := flags.Int("stations", 20, "number of stations, at least 2")
+	degree := flags.Int("degree", 0, "average connections per station (default 4)")
+	seed := flags.Int64("seed", 0, "seed of the random map, picked and printed if not set")
+	topology := flags.String("topology", "random", "shape of the network: random, grid, geometric, small-world, scale-free or planar")
+	radius := flags.Int("radius", 0, "with -topology=planar, connect stations at most N apart instead of the nearest ones")
+	parseFlags(flags, args, &mapFile)
+
+	if mapFile == "" {
+		usageError(flags, "A map file to write is needed.")
+	}
+	t, err := pathfinder.ParseTopology(*topology)
+	if err != nil {
+		usageError(flags, err.Error())
+	}
+	opts := pathfinder.GenerateOptions{Stations: *stations, Degree: *degree, Topology: t, Radius: *radius}
+	opts.Seed = flagSeed(flags, *seed)
+	generate(*names, mapFile, opts)
+}
+
+// flagSeed returns the -seed flag, or a new seed if it was not set.
+func flagSeed(flags *flag.FlagSet, seed int64) int64 {
+	if !isSet(flags, "seed") {
+		return time.Now().UnixNano()
+	}
+	return seed
+}
+
+// isSet reports whether the flag name was given.
+func isSet(flags *flag.FlagSet, name string) bool {
+	set := false
+	flags.Visit(func(f *flag.Flag) { set = set || f.Name == name })
+	return set
+}
+
+// generate writes a random map and prints its seed, so the map can be made
+// again.
+func generate(namesFile, mapFile string, opts pathfinder.GenerateOptions) {
+	mapFile, err := pathfinder.GenerateMapFile(namesFile, mapFile, opts)
+	if err != nil {
+		exitWithError(err.Error(), false)
+	}
+	fmt.Fprintf(stdout, ".map file successfully created: %s with %d stations (%s, seed %d).\n", mapFile, opts.Stations, opts.Topology, opts.Seed)
+}
+
+// generateScenario writes a map with planted routes and its known optimum.
+func generateScenario(args []string) {
+	flags := newFlagSet("scenario")
+	var mapFile string
+	flags.StringVar(&mapFile, "map", "", "map file to write, .map is added if missing")
+	seed := flags.Int64("seed", 0, "seed of the random map, picked and printed if not set")
+	routes := flags.String("routes", "3,4,6", "connections on each planted route, comma separated")
+	trains := flags.Int("trains", 0, "trains to work out the optimum for (default one per route)")
+	decoys := flags.Int("decoys", 0, "detours that leave a route and rejoin it further on")
+	deadEnds := flags.Int("dead-ends", 0, "spurs that lead nowhere")
+	traps := flags.Int("traps", 0, "shortcuts from a longer route into a shorter one that mislead greedy search")
+	parseFlags(flags, args, &mapFile)
+	if mapFile == "" {
+		usageError(flags, "A map file to write is needed.")
+	}
+
+	opts := pathfinder.ScenarioOptions{Trains: *trains, Decoys: *decoys, DeadEnds: *deadEnds, Traps: *traps}
+	for _, field := range strings.Split(*routes, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil {
+			exitWithError(fmt.Sprintf("Invalid route length: %q", field), false)
+		}
+		opts.Routes = append(opts.Routes, n)
+	}
+	opts.Seed = flagSeed(flags, *seed)
+
+	mapFile, optimumFile, err := pathfinder.GenerateScenarioFiles(mapFile, opts)
+	if err != nil {
+		exitWithError(err.Error(), false)
+	}
+	fmt.Fprintf(stdout, "Scenario successfully created: %s with its optimum in %s (seed %d).\n", mapFile, optimumFile, opts.Seed)
+}
+
+// validateCommand checks every map file given and exits with status 1 if
+// any has problems. With -schedule it checks a movement log against the
+// one map instead.
+func validateCommand(args []string) {
+	flags := newFlagSet("validate")
+	maxErrors, limits := mapFlags(flags)
+	schedule := flags.String("schedule", "", "movement log to check against the map, as printed by route")
+	start := flags.String("start", "", "with -schedule, start station")
+	end := flags.String("end", "", "with -schedule, end station")
+	trains := flags.Int("trains", 0, "with -schedule, number of trains")
+	policy := flags.String("conflicts", "edge", "with -schedule, track rule: edge, directional, block or block:N")
+	mapFiles := parseArgs(flags, args)
+	if len(mapFiles) == 0 {
+		usageError(flags, "At least one map file is needed.")
+	}
+
+	if *schedule != "" {
+		if len(mapFiles) != 1 || *start == "" || *end == "" || *trains < 1 {
+			usageError(flags, "-schedule needs one map file, -start, -end and -trains.")
+		}
+		p, err := pathfinder.ParseConflictPolicy(*policy)
+		if err != nil {
+			usageError(flags, err.Error())
+		}
+		validateSchedule(loadMap(mapFiles[0], *maxErrors, *limits), *schedule, *start, *end, *trains, p)
+		return
+	}
+
+	invalid := 0
+	for _, mapFile := range mapFiles {
+		_, errs := pathfinder.ValidateMapFileWithOptions(mapFile, *maxErrors, *limits)
+		if len(errs) == 0 {
+			fmt.Fprintf(stdout, green+"ok"+reset+"  %s\n", mapFile)
+			continue
+		}
+		invalid++
+		fmt.Fprintf(stdout, red+"%d error(s)"+reset+"  %s\n", len(errs), mapFile)
+		for _, err := range errs {
+			fmt.Fprintf(stdout, "  %s\n", err)
+		}
+	}
+	if invalid > 0 {
+		os.Exit(1)
+	}
+}
+
+func validateSchedule(graph *pathfinder.Graph, scheduleFile, start, end string, trains int, policy pathfinder.ConflictPolicy) {
+	file, err := os.Open(scheduleFile)
+	if err != nil {
+		exitWithError(err.Error(), false)
+	}
+	defer file.Close()
+	schedule, err := pathfinder.ParseSchedule(file)
+	if err != nil {
+		exitWithError(err.Error(), false)
+	}
+	violations := pathfinder.ValidateScheduleWithPolicy(graph, start, end, trains, schedule, policy)
+	if len(violations) == 0 {
+		fmt.Fprintf(stdout, green+"ok"+reset+"  %s (%d turns)\n", scheduleFile, schedule.TotalTurns)
+		return
+	}
+	fmt.Fprintf(stdout, red+"%d violation(s)"+reset+"  %s\n", len(violations), scheduleFile)
+	for _, v := range violations {
+		fmt.Fprintf(stdout, "  %s\n", v)
+	}
+	os.Exit(1)
+}
+
+// statsCommand prints counts and connectivity for each map, and with
+// -start and -end what a route between them can use.
+func statsCommand(args []string) {
+	flags := newFlagSet("stats")
+	maxErrors, limits := mapFlags(flags)
+	start := flags.String("start", "", "also report the paths from this station")
+	end := flags.String("end", "", "to this station")
+	mapFiles := parseArgs(flags, args)
+	if len(mapFiles) == 0 {
+		usageError(flags, "At least one map file is needed.")
+	}
+	if (*start == "") != (*end == "") {
+		usageError(flags, "-start and -end go together.")
+	}
+
+	for i, mapFile := range mapFiles {
+		if i > 0 {
+			fmt.Fprintln(stdout)
+		}
+		graph := loadMap(mapFile, *maxErrors, *limits)
+		fmt.Fprintln(stdout, green+mapFile+reset)
+		printStats(graph)
+		if *start == "" {
+			continue
+		}
+		checkStations(graph, *start, *end)
+		paths := pathfinder.FindOptimalPaths(graph, *start, *end, len(graph.Stations))
+		fmt.Fprintf(stdout, "  disjoint paths:  %d from %s to %s\n", len(paths), *start, *end)
+		if shortest := pathfinder.KShortestPaths(graph, *start, *end, 1); len(shortest) > 0 {
+			fmt.Fprintf(stdout, "  shortest path:   %d turns\n", graph.PathWeight(shortest[0]))
+		}
+	}
+}
+
+func printStats(graph *pathfinder.Graph) {
+	connections, oneWay, weighted, capacity := 0, 0, 0, 0
+	// Both directions of every track, to find the groups of stations
+	neighbors := make(map[string][]string, len(graph.Stations))
+	for a, nbrs := range graph.Connections {
+		for _, b := range nbrs {
+			if !graph.IsOneWay(a, b) && b < a {
+				continue // two-way tracks are listed from both ends
+			}
+			connections++
+			if graph.IsOneWay(a, b) {
+				oneWay++
+			}
+			if graph.Weight(a, b) > 1 {
+				weighted++
+			}
+			neighbors[a] = append(neighbors[a], b)
+			neighbors[b] = append(neighbors[b], a)
+		}
+	}
+	degrees := make([]int, 0, len(graph.Stations))
+	for name, station := range graph.Stations {
+		degrees = append(degrees, len(graph.Connections[name]))
+		capacity += station.Capacity
+	}
+	slices.Sort(degrees)
+
+	fmt.Fprintf(stdout, "  stations:        %d (room for %d trains)\n", len(graph.Stations), capacity)
+	fmt.Fprintf(stdout, "  connections:     %d (%d one-way, %d longer than one turn)\n", connections, oneWay, weighted)
+	if len(degrees) > 0 {
+		fmt.Fprintf(stdout, "  departures:      min %d, median %d, max %d per station\n", degrees[0], degrees[len(degrees)/2], degrees[len(degrees)-1])
+	}
+	fmt.Fprintf(stdout, "  groups:          %d (stations that can reach each other, ignoring direction)\n", countGroups(graph, neighbors))
+}
+
+func countGroups(graph *pathfinder.Graph, neighbors map[string][]string) int {
+	seen := make(map[string]bool, len(graph.Stations))
+	groups := 0
+	for name := range graph.Stations {
+		if seen[name] {
+			continue
+		}
+		groups++
+		seen[name] = true
+		queue := []string{name}
+		for len(queue) > 0 {
+			for _, nbr := range neighbors[queue[0]] {
+				if !seen[nbr] {
+					seen[nbr] = true
+					queue = append(queue, nbr)
+				}
+			}
+			queue = queue[1:]
+		}
+	}
+	return groups
+}
+
+func helpCommand(args []string) {
+	if len(args) == 0 {
+		help()
+		return
+	}
+	if _, ok := commands[args[0]]; !ok {
+		exitWithError(fmt.Sprintf("Unknown command %q", args[0]), true)
+	}
+	// Every command prints its help and exits on -h
+	commands[args[0]].run([]string{"-h"})
+}
+
+// ---- Legacy Form ----
+
+// legacy runs the positional form kept for existing scripts:
+// [map file] [start] [end] [trains] to route, or
+// [txt file] [map file] [number of stations] -g to generate.
+func legacy(osArgs []string) {
+	for _, arg := range osArgs {
+		if arg == "-h" || arg == "--help" {
 			help()
 			return
 		}
 	}
 
-	if len(os.Args) != 5 {
+	// Options may appear anywhere; strip them before reading positional args
+	opts := routeOptions{maxErrors: 10, output: outputText}
+	var seed *int64
+	var topology pathfinder.Topology
+	radius := 0
+	var args []string
+	for _, arg := range osArgs {
+		if value, ok := strings.CutPrefix(arg, "--max-errors="); ok {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				exitWithError("--max-errors must be a non-negative integer", true)
+			}
+			opts.maxErrors = n
+			continue
+		}
+		if value, ok := cutGenerateOption(arg, "topology"); ok {
+			t, err := pathfinder.ParseTopology(value)
+			if err != nil {
+				exitWithError(err.Error(), true)
+			}
+			topology = t
+			continue
+		}
+		if value, ok := cutGenerateOption(arg, "radius"); ok {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				exitWithError("-radius must be a positive integer", true)
+			}
+			radius = n
+			continue
+		}
+		if value, ok := cutGenerateOption(arg, "seed"); ok {
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				exitWithError("-seed must be an integer", true)
+			}
+			seed = &n
+			continue
+		}
+		if arg == "--no-color" {
+			disableColor()
+			continue
+		}
+		if value, ok := cutRouteOption(arg, "--format=", "--output="); ok {
+			if err := setOutput(value); err != nil {
+				exitWithError(err.Error(), true)
+			}
+			opts.output = value
+			continue
+		}
+		if arg == "--geometric" {
+			opts.geometric = true
+			continue
+		}
+		if value, ok := strings.CutPrefix(arg, "--disjoint="); ok {
+			d, err := pathfinder.ParseDisjointness(value)
+			if err != nil {
+				exitWithError(err.Error(), true)
+			}
+			opts.disjoint = d
+			continue
+		}
+		if value, ok := strings.CutPrefix(arg, "--k-shortest="); ok {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				exitWithError("--k-shortest must be a positive integer", true)
+			}
+			opts.kShortest = n
+			continue
+		}
+		if value, ok := strings.CutPrefix(arg, "--conflicts="); ok {
+			p, err := pathfinder.ParseConflictPolicy(value)
+			if err != nil {
+				exitWithError(err.Error(), true)
+			}
+			opts.policy = &p
+			continue
+		}
+		if value, ok := strings.CutPrefix(arg, "--max-stations="); ok {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				exitWithError("--max-stations must be a non-negative integer", true)
+			}
+			opts.limits.MaxStations = n
+			if n == 0 {
+				opts.limits.MaxStations = pathfinder.NoLimit
+			}
+			continue
+		}
+		args = append(args, arg)
+	}
+
+	if len(args) != 4 {
 		exitWithError("Incorrect number of arguments.", true)
 	}
-	if os.Args[4] == "-g" {
-		pathfinder.Generator() // Generate a map file
+	if args[3] == "-g" {
+		numStations, err := strconv.Atoi(args[2])
+		if err != nil || numStations < 2 {
+			exitWithError(fmt.Sprintf("Invalid number of stations: %s (must be at least 2)", args[2]), false)
+		}
+		opts := pathfinder.GenerateOptions{Stations: numStations, Topology: topology, Radius: radius}
+		// Without a seed one is picked and printed, so the map can be made again
+		opts.Seed = time.Now().UnixNano()
+		if seed != nil {
+			opts.Seed = *seed
+		}
+		generate(args[0], args[1], opts)
 		return
 	}
 
-	mapFile := os.Args[1]
-	start := os.Args[2]
-	end := os.Args[3]
-	numTrains, err := strconv.Atoi(os.Args[4])
-	if err != nil || numTrains < 0 {
-		exitWithError("Number of trains must be a positive integer", false)
+	opts.mapFile, opts.start, opts.end = args[0], args[1], args[2]
+	opts.trains = parseTrains(args[3])
+	route(opts)
+}
+
+// cutGenerateOption accepts both -name=value and --name=value.
+func cutGenerateOption(arg, name string) (string, bool) {
+	if value, ok := strings.CutPrefix(arg, "--"+name+"="); ok {
+		return value, true
 	}
-	if numTrains == 0 {
-		exitWithError("Number of trains must be greater than 0", false)
+	return strings.CutPrefix(arg, "-"+name+"=")
+}
+
+// cutRouteOption accepts any of the given prefixes.
+func cutRouteOption(arg string, prefixes ...string) (string, bool) {
+	for _, prefix := range prefixes {
+		if value, ok := strings.CutPrefix(arg, prefix); ok {
+			return value, true
+		}
 	}
+	return "", false
+}
+
+// ---- Routing ----
+
+// fail exits with an error, as JSON under code when the output is JSON.
+func fail(code, msg string) {
+	if jsonOutput {
+		pathfinder.WriteErrorsJSON(os.Stdout, []pathfinder.ErrorReport{{Code: code, Message: msg}})
+		os.Exit(1)
+	}
+	exitWithError(msg, false)
+}
 
-	graph, err := pathfinder.ParseMapFile(mapFile)
+func parseTrains(s string) int {
+	numTrains, err := strconv.Atoi(s)
 	if err != nil {
-		exitWithError(fmt.Sprintf("Error parsing map: %s", err), false)
+		fail("invalid-trains", "Number of trains must be a positive integer")
+	}
+	checkTrains(numTrains)
+	return numTrains
+}
+
+func checkTrains(numTrains int) {
+	if numTrains < 0 {
+		fail("invalid-trains", "Number of trains must be a positive integer")
+	}
+	if numTrains == 0 {
+		fail("invalid-trains", "Number of trains must be greater than 0")
+	}
+}
+
+// loadMap parses a map file or exits with every problem found.
+func loadMap(mapFile string, maxErrors int, limits pathfinder.ParseOptions) *pathfinder.Graph {
+	graph, errs := pathfinder.ValidateMapFileWithOptions(mapFile, maxErrors, limits)
+	if len(errs) > 0 && jsonOutput {
+		pathfinder.WriteErrorsJSON(os.Stdout, pathfinder.NewErrorReports(errs))
+		os.Exit(1)
+	}
+	if len(errs) == 1 {
+		exitWithError(fmt.Sprintf("Error parsing map: %s", errs[0]), false)
+	}
+	if len(errs) > 1 {
+		msg := fmt.Sprintf("Errors parsing map (%d):\n%s", len(errs), errs)
+		if len(errs) == maxErrors {
+			msg += fmt.Sprintf("\nStopped after %d errors, use --max-errors=N to see more (0 for all)", maxErrors)
+		}
+		exitWithError(msg, false)
 	}
+	return graph
+}
+
+func checkStations(graph *pathfinder.Graph, start, end string) {
 	if _, ok := graph.Stations[start]; !ok {
-		exitWithError(fmt.Sprintf("Start station, %q does not exist", start), false)
+		fail("unknown-start", fmt.Sprintf("Start station, %q does not exist", start))
 	}
 	if _, ok := graph.Stations[end]; !ok {
-		exitWithError(fmt.Sprintf("End station, %q does not exist", end), false)
+		fail("unknown-end", fmt.Sprintf("End station, %q does not exist", end))
 	}
 	if start == end {
-		exitWithError(fmt.Sprintf("Start and end stations, %q and %q are the same", start, end), false)
+		fail("same-stations", fmt.Sprintf("Start and end stations, %q and %q are the same", start, end))
 	}
+}
 
-	paths := pathfinder.FindMultiplePaths(graph, start, end, numTrains)
+// route finds the paths and runs the trains. Paths and predicted turns go
+// to info and the schedule to out; with -output=turns only the schedule is
+// written, without colours, and with json or ndjson only the report.
+func route(opts routeOptions) {
+	graph := loadMap(opts.mapFile, opts.maxErrors, opts.limits)
+	checkStations(graph, opts.start, opts.end)
+	info, out := stdout, stdout
+	switch opts.output {
+	case outputTurns:
+		info, out = io.Discard, plainWriter{stdout}
+	case outputJSON, outputNDJSON:
+		info, out = io.Discard, io.Discard
+	}
+
+	if opts.kShortest > 0 {
+		routeAlternatives(graph, opts, info, out)
+		return
+	}
+
+	paths, turns := pathfinder.FindFastestPathsWithOptions(graph, opts.start, opts.end, opts.trains, pathfinder.PathOptions{Disjoint: opts.disjoint})
+	if opts.geometric {
+		// The same paths, physically shortest first; a greedy geometric
+		// search could block paths the optimal set finds
+		slices.SortStableFunc(paths, func(a, b []string) int {
+			return cmp.Compare(graph.PathLength(a), graph.PathLength(b))
+		})
+	}
 
 	if len(paths) == 0 {
-		exitWithError(fmt.Sprintf("No path between %q and %q stations.", start, end), false)
+		fail("no-path", fmt.Sprintf("No path between %q and %q stations.", opts.start, opts.end))
 	}
 
-	fmt.Println(green+"Paths found:"+reset)
+	fmt.Fprintln(info, green+"Paths found:"+reset)
 	for i, path := range paths {
-		fmt.Printf(green+"Path %d:"+reset+" %s\n", i+1, strings.Join(path, " -> "))
+		if opts.geometric {
+			fmt.Fprintf(info, green+"Path %d:"+reset+" %s (length %.1f)\n", i+1, strings.Join(path, " -> "), graph.PathLength(path))
+			continue
+		}
+		fmt.Fprintf(info, green+"Path %d:"+reset+" %s\n", i+1, strings.Join(path, " -> "))
 	}
-	fmt.Println()
+	fmt.Fprintf(info, green+"Predicted turns:"+reset+" %d\n", turns)
+	fmt.Fprintln(info)
 
-	trains := pathfinder.AssignToPipelines(paths, numTrains)
-	pathfinder.SimulateMovements(trains)
+	trains := graph.AssignToPipelines(paths, opts.trains)
+	report(graph, paths, trains, turns, simulate(graph, trains, opts.policy, info, out), opts.output)
+}
+
+// routeAlternatives prints the k shortest paths with the turns they take and
+// runs the trains over all of them, leaving shared stations to the simulator.
+func routeAlternatives(graph *pathfinder.Graph, opts routeOptions, info, out io.Writer) {
+	paths := pathfinder.KShortestPaths(graph, opts.start, opts.end, opts.kShortest)
+	if len(paths) == 0 {
+		fail("no-path", fmt.Sprintf("No path between %q and %q stations.", opts.start, opts.end))
+	}
+
+	fmt.Fprintf(info, green+"%d shortest paths:"+reset+"\n", len(paths))
+	for i, path := range paths {
+		fmt.Fprintf(info, green+"Path %d:"+reset+" %s (%d turns)\n", i+1, strings.Join(path, " -> "), graph.PathWeight(path))
+	}
+	fmt.Fprintln(info)
+
+	// Paths that share stations have no prediction
+	trains := graph.AssignToPipelines(paths, opts.trains)
+	report(graph, paths, trains, 0, simulate(graph, trains, opts.policy, info, out), opts.output)
+}
+
+// simulate writes the schedule to out, and with an explicit policy also
+// lists every time a train was held back to info.
+func simulate(graph *pathfinder.Graph, trains []*pathfinder.Train, policy *pathfinder.ConflictPolicy, info, out io.Writer) *pathfinder.Schedule {
+	if policy == nil {
+		schedule := graph.Simulate(trains)
+		pathfinder.RenderSchedule(out, schedule)
+		return schedule
+	}
+	schedule := pathfinder.SimulateWithPolicy(graph, trains, *policy)
+	pathfinder.RenderSchedule(out, schedule)
+	pathfinder.RenderConflicts(info, schedule)
+	return schedule
+}
+
+// report writes the route as JSON or ndjson when that output was chosen.
+// A schedule some trains never finish is an error instead, after the text
+// schedule so the stuck trains can be seen.
+func report(graph *pathfinder.Graph, paths [][]string, trains []*pathfinder.Train, predicted int, schedule *pathfinder.Schedule, output string) {
+	if schedule.Arrived < schedule.Trains {
+		fail("not-arrived", fmt.Sprintf("Only %d of %d trains arrived; the others are stuck.", schedule.Arrived, schedule.Trains))
+	}
+	if !jsonOutput {
+		return
+	}
+	r := pathfinder.NewRouteReport(graph, paths, trains, predicted, schedule)
+	var err error
+	switch output {
+	case outputJSON:
+		err = r.WriteJSON(os.Stdout)
+	case outputNDJSON:
+		err = r.WriteNDJSON(os.Stdout)
+	}
+	if err != nil {
+		exitWithError(err.Error(), false)
+	}
+}
+
+// ---- Formatting ----
+
+// formatMaps rewrites map files in canonical form, or with -check only
+// reports the ones that are not formatted and exits with status 1.
+func formatMaps(args []string) {
+	flags := newFlagSet("fmt")
+	maxErrors, limits := mapFlags(flags)
+	check := flags.Bool("check", false, "report files that are not formatted instead of rewriting them")
+	keepOrder := flags.Bool("keep-order", false, "keep stations and connections in file order instead of sorting them")
+	mapFiles := parseArgs(flags, args)
+	if len(mapFiles) == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	// Files that cannot be formatted are reported and skipped, so one run
+	// shows all of them
+	unformatted, invalid := 0, 0
+	skip := func(msg string) {
+		fmt.Fprintln(stderr, red+"Error: "+reset, yellow+msg+reset)
+		invalid++
+	}
+	for _, mapFile := range mapFiles {
+		graph, errs := pathfinder.ValidateMapFileWithOptions(mapFile, *maxErrors, *limits)
+		if len(errs) > 0 {
+			skip(fmt.Sprintf("Error parsing map:\n%s", errs))
+			continue
+		}
+		original, err := os.ReadFile(mapFile)
+		if err != nil {
+			skip(err.Error())
+			continue
+		}
+		var formatted bytes.Buffer
+		if err := pathfinder.WriteMapWithOptions(&formatted, graph, pathfinder.WriteOptions{KeepOrder: *keepOrder}); err != nil {
+			skip(err.Error())
+			continue
+		}
+		if bytes.Equal(original, formatted.Bytes()) {
+			continue
+		}
+		if *check {
+			fmt.Fprintln(stdout, mapFile)
+			unformatted++
+			continue
+		}
+		if err := os.WriteFile(mapFile, formatted.Bytes(), 0o644); err != nil {
+			skip(err.Error())
+		}
+	}
+	if unformatted > 0 || invalid > 0 {
+		os.Exit(1)
+	}
+}
+
+// ---- Output ----
+
+var ansiCodes = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+
+// plainWriter drops colour codes from everything written through it.
+type plainWriter struct{ w io.Writer }
+
+func (p plainWriter) Write(b []byte) (int, error) {
+	if _, err := p.w.Write(ansiCodes.ReplaceAll(b, nil)); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func disableColor() {
+	stdout, stderr = plainWriter{os.Stdout}, plainWriter{os.Stderr}
 }
 
 func help() {
+	fmt.Println("Usage: go run . [command] [flags] [args], with commands:")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		fmt.Printf("  %-9s %s\n", name, commands[name].summary)
+	}
+	fmt.Println("Run go run . help [command] for its flags. Every command takes --no-color.")
+	fmt.Println()
+	fmt.Println("The positional form also works:")
 	fmt.Println("To find train routes, use: go run . [path to file containing network map] [start station] [end station] [number of trains]")
+	fmt.Println("  --max-errors=N  report at most N problems in the map file (default 10, 0 for all)")
+	fmt.Println("  --geometric  list the paths by straight-line length and print their lengths")
+	fmt.Println("  --disjoint=D  what paths may not share: station (default), edge or none")
+	fmt.Println("  --k-shortest=K  list the K shortest paths, which may share stations, and run the trains over them")
+	fmt.Println("  --conflicts=P  track rule: edge (default), directional, block or block:N, and list held trains")
+	fmt.Println("  --max-stations=N  accept maps with at most N stations (default 10000, 0 for no limit)")
+	fmt.Println("  --format=F  output format: text (default), turns, json or ndjson; --output=F also works")
+	fmt.Println("  --no-color  print without colours")
 	fmt.Println("To generate a map file, use: go run main.go [txt file] [map file] [number of stations] -g")
+	fmt.Println("  -seed=N  seed of the random map, to make the same map again")
+	fmt.Println("  -topology=T  shape of the network: random (default), grid, geometric, small-world, scale-free or planar")
+	fmt.Println("  -radius=N  with -topology=planar, connect stations at most N apart instead of the nearest ones")
 	fmt.Println("Run error tests: go test -v")
 }
 
 func exitWithError(msg string, showHelp bool) {
-	fmt.Fprintln(os.Stderr, red+"Error: "+reset, yellow+msg+reset)
+	fmt.Fprintln(stderr, red+"Error: "+reset, yellow+msg+reset)
 	if showHelp {
 		help()
 	}
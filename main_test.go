@@ -1,7 +1,11 @@
 package main
 
 import (
+	"encoding/json"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"pathfinder/pathfinder"
 	"strings"
 	"testing"
 )
@@ -97,6 +101,11 @@ var testData = []struct {
 	{[]string{"go", "run", "main.go", "testdata/10001.map", "waterloo", "st_pancras", "2"},
 		"The map file contains more than 10000 stations\ngo run main.go testdata/10001.map waterloo st_pancras 2",
 		"expected error for more than 10000 stations, got"},
+
+	// ### TestManyErrors ###
+	{[]string{"go", "run", "main.go", "testdata/LondonManyErrors.map", "waterloo", "st_pancras", "2"},
+		"Every problem in the map file is reported at once\ngo run main.go testdata/LondonManyErrors.map waterloo st_pancras 2",
+		"expected all map errors, got"},
 }
 
 var count int // Bad idea if you want to run tests in parallel t.Parallel()
@@ -178,3 +187,164 @@ func TestWithoutConnections(t *testing.T) {
 func TestMapWith10001Stations(t *testing.T) {
 	errorTest(t, testData[count].command, testData[count].message, testData[count].errorMsg)
 }
+
+func TestManyErrors(t *testing.T) {
+	command := testData[count].command
+	errorTest(t, command, testData[count].message, testData[count].errorMsg)
+
+	out, _ := exec.Command(command[0], command[1:]...).CombinedOutput()
+	for _, want := range []string{"4:1: invalid station name", "6:1: duplicate station", "7:12: the stations", "11:10: unknown station", "12:1: duplicate connection"} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf(red+"expected %q in: %s"+reset, want, out)
+		}
+	}
+}
+
+func TestFmtCheck(t *testing.T) {
+	original, err := os.ReadFile("testdata/London.map")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mapFile := filepath.Join(t.TempDir(), "London.map")
+	if err := os.WriteFile(mapFile, original, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if out, err := exec.Command("go", "run", "main.go", "fmt", "-check", mapFile).CombinedOutput(); err == nil {
+		t.Errorf(red+"expected -check to fail on an unformatted map: %s"+reset, out)
+	}
+	if out, err := exec.Command("go", "run", "main.go", "fmt", mapFile).CombinedOutput(); err != nil {
+		t.Fatalf(red+"fmt failed: %v %s"+reset, err, out)
+	}
+	if out, err := exec.Command("go", "run", "main.go", "fmt", "-check", mapFile).CombinedOutput(); err != nil {
+		t.Errorf(red+"expected -check to pass after fmt: %v %s"+reset, err, out)
+	}
+
+	// Every map that cannot be parsed is reported, not just the first
+	out, err := exec.Command("go", "run", "main.go", "fmt", "-check", "-max-errors", "1", "testdata/LondonManyErrors.map", "testdata/LondonStationNameInvalid.map").CombinedOutput()
+	if err == nil || !strings.Contains(string(out), "LondonManyErrors.map:") || !strings.Contains(string(out), "LondonStationNameInvalid.map:") {
+		t.Errorf(red+"expected both invalid maps reported: %v\n%s"+reset, err, out)
+	}
+	t.Logf(green + "fmt -check detects unformatted maps" + reset)
+}
+
+func TestSubcommands(t *testing.T) {
+	legacy, err := exec.Command("go", "run", "main.go", "testdata/London.map", "waterloo", "st_pancras", "4").CombinedOutput()
+	if err != nil {
+		t.Fatalf(red+"positional form failed: %v %s"+reset, err, legacy)
+	}
+	named, err := exec.Command("go", "run", "main.go", "route", "-map", "testdata/London.map", "-start", "waterloo", "-end", "st_pancras", "-trains", "4").CombinedOutput()
+	if err != nil || string(named) != string(legacy) {
+		t.Errorf(red+"route with named flags differs from the positional form: %v\n%s"+reset, err, named)
+	}
+
+	plain, err := exec.Command("go", "run", "main.go", "route", "--no-color", "testdata/London.map", "waterloo", "st_pancras", "4").CombinedOutput()
+	if err != nil || strings.Contains(string(plain), "\033") || !strings.Contains(string(plain), "Turn 3:") {
+		t.Errorf(red+"expected the schedule without colours: %v\n%q"+reset, err, plain)
+	}
+
+	trailing, err := exec.Command("go", "run", "main.go", "route", "testdata/London.map", "waterloo", "st_pancras", "4", "--no-color").CombinedOutput()
+	if err != nil || string(trailing) != string(plain) {
+		t.Errorf(red+"expected flags after the arguments to work: %v\n%s"+reset, err, trailing)
+	}
+
+	schedule := filepath.Join(t.TempDir(), "schedule.txt")
+	turns, err := exec.Command("go", "run", "main.go", "route", "-output", "turns", "testdata/London.map", "waterloo", "st_pancras", "4").Output()
+	if err != nil || strings.Contains(string(turns), "Path") {
+		t.Fatalf(red+"expected the schedule alone: %v\n%s"+reset, err, turns)
+	}
+	if err := os.WriteFile(schedule, turns, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := exec.Command("go", "run", "main.go", "validate", "-schedule", schedule, "-start", "waterloo", "-end", "st_pancras", "-trains", "4", "testdata/London.map").CombinedOutput(); err != nil {
+		t.Errorf(red+"expected the schedule to validate: %v %s"+reset, err, out)
+	}
+
+	if out, err := exec.Command("go", "run", "main.go", "validate", "testdata/London.map", "testdata/LondonManyErrors.map").CombinedOutput(); err == nil || !strings.Contains(string(out), "5 error(s)") {
+		t.Errorf(red+"expected validate to report the broken map: %v %s"+reset, err, out)
+	}
+	if out, err := exec.Command("go", "run", "main.go", "stats", "-start", "waterloo", "-end", "st_pancras", "testdata/London.map").CombinedOutput(); err != nil || !strings.Contains(string(out), "disjoint paths:  2") {
+		t.Errorf(red+"unexpected stats: %v %s"+reset, err, out)
+	}
+	if out, err := exec.Command("go", "run", "main.go", "route", "testdata/London.map", "waterloo").CombinedOutput(); err == nil || !strings.Contains(string(out), "Error") {
+		t.Errorf(red+"expected an error for missing arguments: %s"+reset, out)
+	}
+	if out, _ := exec.Command("go", "run", "main.go", "route", "testdata/London.map", "waterloo", "st_pancras", "-1").CombinedOutput(); !strings.Contains(string(out), "Number of trains must be a positive integer") {
+		t.Errorf(red+"expected a negative train count to be reported: %s"+reset, out)
+	}
+	if out, _ := exec.Command("go", "run", "main.go", "route", "-trains", "2", "testdata/London.map", "waterloo", "st_pancras", "2").CombinedOutput(); !strings.Contains(string(out), "repeats a flag") {
+		t.Errorf(red+"expected -trains and a train count to clash: %s"+reset, out)
+	}
+	t.Log(green + "subcommands and the positional form agree" + reset)
+}
+
+func TestJSONOutput(t *testing.T) {
+	out, err := exec.Command("go", "run", "main.go", "route", "--format", "json", "testdata/London.map", "waterloo", "st_pancras", "4").Output()
+	var report pathfinder.RouteReport
+	if err != nil || json.Unmarshal(out, &report) != nil || report.Totals.Turns != 3 || len(report.Trains) != 4 {
+		t.Fatalf(red+"unexpected JSON route: %v\n%s"+reset, err, out)
+	}
+
+	// The positional form takes --format too, and ndjson has one record per line
+	out, err = exec.Command("go", "run", "main.go", "testdata/London.map", "waterloo", "st_pancras", "4", "--format=ndjson").Output()
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	if err != nil || len(lines) != report.Totals.Turns+2 || !strings.Contains(lines[len(lines)-1], `"type":"totals"`) {
+		t.Errorf(red+"unexpected ndjson route: %v\n%s"+reset, err, out)
+	}
+
+	out, err = exec.Command("go", "run", "main.go", "route", "-format", "json", "testdata/LondonManyErrors.map", "waterloo", "st_pancras", "4").Output()
+	var doc struct {
+		Schema int
+		Errors []pathfinder.ErrorReport
+	}
+	if err == nil || json.Unmarshal(out, &doc) != nil || doc.Schema != pathfinder.SchemaVersion || len(doc.Errors) != 5 || doc.Errors[0].Code != "invalid-name" {
+		t.Errorf(red+"expected a JSON parse error: %v\n%s"+reset, err, out)
+	}
+
+	// Flag errors are JSON too, wherever the output flag is
+	for _, args := range [][]string{
+		{"-format", "json", "-bogus", "testdata/London.map", "waterloo", "st_pancras", "4"},
+		{"-bogus", "testdata/London.map", "waterloo", "st_pancras", "4", "-output=ndjson"},
+	} {
+		out, err = exec.Command("go", append([]string{"run", "main.go", "route"}, args...)...).Output()
+		if err == nil || json.Unmarshal(out, &doc) != nil || len(doc.Errors) != 1 || doc.Errors[0].Code != "usage" {
+			t.Errorf(red+"expected a JSON usage error for %v: %v\n%s"+reset, args, err, out)
+		}
+	}
+	t.Log(green + "route writes JSON, ndjson and JSON errors" + reset)
+}
+
+func TestGeometricRoute(t *testing.T) {
+	for _, args := range [][]string{
+		{"testdata/small.map", "small", "large", "9"},
+		{"testdata/beginning.map", "beginning", "terminus", "20"},
+	} {
+		var plain, geometric pathfinder.RouteReport
+		out, err := exec.Command("go", append([]string{"run", "main.go", "route", "-format", "json"}, args...)...).Output()
+		if err != nil || json.Unmarshal(out, &plain) != nil {
+			t.Fatalf(red+"route failed: %v\n%s"+reset, err, out)
+		}
+		out, err = exec.Command("go", append([]string{"run", "main.go", "route", "-format", "json", "-geometric"}, args...)...).Output()
+		if err != nil || json.Unmarshal(out, &geometric) != nil {
+			t.Fatalf(red+"geometric route failed: %v\n%s"+reset, err, out)
+		}
+
+		// The same paths and turns, listed by length
+		if len(geometric.Paths) != len(plain.Paths) || geometric.Totals.PredictedTurns != plain.Totals.PredictedTurns {
+			t.Errorf(red+"%s: %d paths in %d turns, want %d in %d"+reset, args[0], len(geometric.Paths), geometric.Totals.PredictedTurns, len(plain.Paths), plain.Totals.PredictedTurns)
+		}
+		found := make(map[string]bool)
+		for _, p := range plain.Paths {
+			found[strings.Join(p.Stations, "-")] = true
+		}
+		for i, p := range geometric.Paths {
+			if !found[strings.Join(p.Stations, "-")] {
+				t.Errorf(red+"%s: path %v is not one route finds"+reset, args[0], p.Stations)
+			}
+			if i > 0 && p.Length < geometric.Paths[i-1].Length {
+				t.Errorf(red+"%s: path %d is shorter than path %d"+reset, args[0], i+1, i)
+			}
+		}
+	}
+	t.Log(green + "geometric routes rank the optimal paths by length" + reset)
+}
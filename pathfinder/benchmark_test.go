@@ -0,0 +1,76 @@
+package pathfinder
+
+import (
+	"testing"
+)
+
+// Benchmarks run on the largest sample map: go test -bench . ./pathfinder
+const (
+	benchMap   = "../testdata/10000.map"
+	benchStart = "montendre"
+	benchEnd   = "brazey_en_morvan"
+)
+
+func loadBenchGraph(b *testing.B) *Graph {
+	b.Helper()
+	graph, err := ParseMapFile(benchMap)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return graph
+}
+
+func BenchmarkFindMultiplePaths(b *testing.B) {
+	graph := loadBenchGraph(b)
+	b.ReportAllocs()
+	for b.Loop() {
+		FindMultiplePaths(graph, benchStart, benchEnd, 20)
+	}
+}
+
+func BenchmarkFindFastestPaths(b *testing.B) {
+	graph := loadBenchGraph(b)
+	b.ReportAllocs()
+	for b.Loop() {
+		FindFastestPaths(graph, benchStart, benchEnd, 20)
+	}
+}
+
+func BenchmarkSimulate(b *testing.B) {
+	graph := loadBenchGraph(b)
+	paths, _ := FindFastestPaths(graph, benchStart, benchEnd, 200)
+	b.ReportAllocs()
+	for b.Loop() {
+		graph.Simulate(graph.AssignToPipelines(paths, 200))
+	}
+}
+
+func BenchmarkCompile(b *testing.B) {
+	graph := loadBenchGraph(b)
+	b.ReportAllocs()
+	for b.Loop() {
+		Compile(graph)
+	}
+}
+
+// The Compiled* benchmarks compile once and only measure the search, which
+// is what a service answering many queries on one map pays.
+func BenchmarkCompiledFindMultiplePaths(b *testing.B) {
+	c := Compile(loadBenchGraph(b))
+	start, _ := c.ID(benchStart)
+	end, _ := c.ID(benchEnd)
+	b.ReportAllocs()
+	for b.Loop() {
+		c.FindMultiplePaths(start, end, 20)
+	}
+}
+
+func BenchmarkCompiledFindFastestPaths(b *testing.B) {
+	c := Compile(loadBenchGraph(b))
+	start, _ := c.ID(benchStart)
+	end, _ := c.ID(benchEnd)
+	b.ReportAllocs()
+	for b.Loop() {
+		c.FindFastestPaths(start, end, 20)
+	}
+}
@@ -0,0 +1,162 @@
+package pathfinder
+
+import (
+	"sort"
+)
+
+// ---- Compiled Graph ----
+
+// CompiledGraph is a read-only form of a Graph for the algorithms: stations
+// get dense integer IDs (their index in the sorted name list) and the
+// adjacency is stored in CSR form, so searches work on slices instead of
+// string-keyed maps. Neighbours keep the order of Graph.Connections, so a
+// one-way connection is only listed at the station it leaves.
+type CompiledGraph struct {
+	Names    []string // station name by ID
+	ids      map[string]int
+	offsets  []int // neighbours of v are adj[offsets[v]:offsets[v+1]]
+	adj      []int
+	weights  []int  // parallel to adj
+	oneWay   []bool // parallel to adj, nil when the graph has no one-way connections
+	capacity []int
+	x, y     []float64 // station coordinates
+}
+
+// Compile builds the compiled form of g. Later changes to g are not seen.
+func Compile(g *Graph) *CompiledGraph {
+	names := make([]string, 0, len(g.Stations))
+	for name := range g.Stations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	c := &CompiledGraph{
+		Names:    names,
+		ids:      make(map[string]int, len(names)),
+		offsets:  make([]int, len(names)+1),
+		capacity: make([]int, len(names)),
+		x:        make([]float64, len(names)),
+		y:        make([]float64, len(names)),
+	}
+	for i, name := range names {
+		c.ids[name] = i
+	}
+	if len(g.OneWay) > 0 {
+		c.oneWay = []bool{}
+	}
+	for i, name := range names {
+		c.capacity[i] = max(g.Stations[name].Capacity, 1)
+		c.x[i], c.y[i] = float64(g.Stations[name].X), float64(g.Stations[name].Y)
+		for _, nbr := range g.Connections[name] {
+			// A graph built by hand may connect to a station it does not
+			// have; the connection leads nowhere, so leave it out
+			id, ok := c.ids[nbr]
+			if !ok {
+				continue
+			}
+			if c.oneWay != nil {
+				c.oneWay = append(c.oneWay, g.IsOneWay(name, nbr))
+			}
+			c.adj = append(c.adj, id)
+			// Skip building edge keys when no connection has a weight
+			weight := 1
+			if len(g.Weights) > 0 {
+				weight = g.Weight(name, nbr)
+			}
+			c.weights = append(c.weights, weight)
+		}
+		c.offsets[i+1] = len(c.adj)
+	}
+	return c
+}
+
+// ID returns the ID of a station.
+func (c *CompiledGraph) ID(name string) (int, bool) {
+	id, ok := c.ids[name]
+	return id, ok
+}
+
+// Neighbors returns the IDs of the stations connected to v.
+// The slice belongs to the graph and must not be changed.
+func (c *CompiledGraph) Neighbors(v int) []int {
+	return c.adj[c.offsets[v]:c.offsets[v+1]]
+}
+
+// Weight returns the weight of the connection from u to v, 0 if there is none.
+func (c *CompiledGraph) Weight(u, v int) int {
+	if i := c.arc(u, v); i >= 0 {
+		return c.weights[i]
+	}
+	return 0
+}
+
+// Capacity returns how many trains station v can hold at once.
+func (c *CompiledGraph) Capacity(v int) int {
+	return c.capacity[v]
+}
+
+// PathWeight returns the total number of turns needed to travel the path.
+func (c *CompiledGraph) PathWeight(path []int) int {
+	total := 0
+	for i := 1; i < len(path); i++ {
+		total += c.Weight(path[i-1], path[i])
+	}
+	return total
+}
+
+func (c *CompiledGraph) weighted() bool {
+	for _, w := range c.weights {
+		if w != 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// PathNames turns a path of IDs back into station names.
+func (c *CompiledGraph) PathNames(path []int) []string {
+	names := make([]string, len(path))
+	for i, id := range path {
+		names[i] = c.Names[id]
+	}
+	return names
+}
+
+func (c *CompiledGraph) pathsNames(paths [][]int) [][]string {
+	if paths == nil {
+		return nil
+	}
+	named := make([][]string, len(paths))
+	for i, path := range paths {
+		named[i] = c.PathNames(path)
+	}
+	return named
+}
+
+// edgeIndex returns the position in adj of the track from u to v. A
+// two-way connection is taken from the lower ID's side so both directions
+// share it; a one-way connection has a track of its own. -1 if there is no
+// such connection.
+func (c *CompiledGraph) edgeIndex(u, v int) int {
+	if i := c.arc(u, v); i >= 0 && c.isOneWay(i) {
+		return i
+	}
+	if u > v {
+		u, v = v, u
+	}
+	return c.arc(u, v)
+}
+
+// arc returns the position in adj of v among the neighbours of u, or -1.
+func (c *CompiledGraph) arc(u, v int) int {
+	for i := c.offsets[u]; i < c.offsets[u+1]; i++ {
+		if c.adj[i] == v {
+			return i
+		}
+	}
+	return -1
+}
+
+func (c *CompiledGraph) isOneWay(i int) bool {
+	return c.oneWay != nil && c.oneWay[i]
+}
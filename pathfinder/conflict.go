@@ -0,0 +1,86 @@
+package pathfinder
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ---- Conflict Policy ----
+
+// ConflictMode decides when a train may enter a track another train uses.
+type ConflictMode int
+
+const (
+	// EdgeLock holds a track for one train while it is on it.
+	EdgeLock ConflictMode = iota
+	// DirectionalLock lets trains follow each other onto a track, one per
+	// turn, but not enter it against trains coming the other way.
+	DirectionalLock
+	// BlockReservation holds a track for ConflictPolicy.Reserve turns once
+	// a train enters it, or while the train is on it if that is longer.
+	BlockReservation
+)
+
+// ConflictPolicy is the track rule used by SimulateWithPolicy and
+// ValidateScheduleWithPolicy. The zero value is EdgeLock, the rule of
+// Simulate and ValidateSchedule.
+type ConflictPolicy struct {
+	Mode    ConflictMode
+	Reserve int // turns, only used by BlockReservation
+}
+
+// ParseConflictPolicy reads a policy name as used on the command line:
+// "edge", "directional", "block" or "block:N" with N turns reserved.
+func ParseConflictPolicy(s string) (ConflictPolicy, error) {
+	name, reserve, hasReserve := strings.Cut(s, ":")
+	switch {
+	case name == "edge" && !hasReserve:
+		return ConflictPolicy{Mode: EdgeLock}, nil
+	case name == "directional" && !hasReserve:
+		return ConflictPolicy{Mode: DirectionalLock}, nil
+	case name == "block" && !hasReserve:
+		return ConflictPolicy{Mode: BlockReservation}, nil
+	case name == "block":
+		n, err := strconv.Atoi(reserve)
+		if err != nil || n < 1 {
+			return ConflictPolicy{}, fmt.Errorf("invalid reservation %q. Reserved turns must be a positive integer", reserve)
+		}
+		return ConflictPolicy{Mode: BlockReservation, Reserve: n}, nil
+	}
+	return ConflictPolicy{}, fmt.Errorf("unknown conflict policy %q. Use edge, directional, block or block:N", s)
+}
+
+func (p ConflictPolicy) String() string {
+	switch p.Mode {
+	case DirectionalLock:
+		return "directional"
+	case BlockReservation:
+		if p.Reserve > 0 {
+			return fmt.Sprintf("block:%d", p.Reserve)
+		}
+		return "block"
+	}
+	return "edge"
+}
+
+// hold returns how many turns a train entering a track of the given weight
+// keeps it from other trains.
+func (p ConflictPolicy) hold(weight int) int {
+	if p.Mode == BlockReservation {
+		return max(weight, p.Reserve)
+	}
+	return weight
+}
+
+// rule names the conflict between a train entering a track and one already
+// holding it; opposite directions on the same track are always head-on.
+func (p ConflictPolicy) rule(opposite bool) Rule {
+	switch {
+	case opposite:
+		return RuleHeadOn
+	case p.Mode == BlockReservation:
+		return RuleBlockReserved
+	}
+	return RuleEdgeOccupied
+}
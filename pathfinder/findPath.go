@@ -1,74 +1,293 @@
 package pathfinder
 
 import (
+	"fmt"
+	"slices"
 	"sort"
 )
 
+// PathOptions changes how paths are searched for and ranked. The zero value
+// is FindMultiplePaths.
+type PathOptions struct {
+	// Disjoint is what the paths may not share.
+	Disjoint Disjointness
+	// Geometric makes the cost of a connection the straight-line distance
+	// between its stations' coordinates instead of its weight. Searches use
+	// A* with the distance to the end station as the heuristic.
+	Geometric bool
+	// RankByLength sorts the paths found by total distance instead of by
+	// the turns they take.
+	RankByLength bool
+}
+
+// Disjointness says what paths found together may not share.
+type Disjointness int
+
+const (
+	// StationDisjoint paths share no intermediate station, except stations
+	// with a capacity of N that may be on N paths.
+	StationDisjoint Disjointness = iota
+	// EdgeDisjoint paths may share stations but not tracks; the simulator
+	// takes trains through shared stations in turn.
+	EdgeDisjoint
+	// NotDisjoint paths may share stations and tracks; they differ in the
+	// track they leave start on.
+	NotDisjoint
+)
+
+// ParseDisjointness reads a mode as used on the command line: "station",
+// "edge" or "none".
+func ParseDisjointness(s string) (Disjointness, error) {
+	switch s {
+	case "station":
+		return StationDisjoint, nil
+	case "edge":
+		return EdgeDisjoint, nil
+	case "none":
+		return NotDisjoint, nil
+	}
+	return 0, fmt.Errorf("unknown disjointness %q. Use station, edge or none", s)
+}
+
+func (d Disjointness) String() string {
+	switch d {
+	case EdgeDisjoint:
+		return "edge"
+	case NotDisjoint:
+		return "none"
+	}
+	return "station"
+}
+
 // Pathfinding
+
+// FindMultiplePaths returns up to maxPaths station-disjoint paths from start
+// to end, shortest first. The graph is only read, so repeated queries give
+// the same answer.
 func FindMultiplePaths(graph *Graph, start, end string, maxPaths int) [][]string {
-	var paths [][]string
-	removed := make(map[string]bool)
+	return FindMultiplePathsWithOptions(graph, start, end, maxPaths, PathOptions{})
+}
+
+// FindMultiplePathsWithOptions is FindMultiplePaths with a choice of costs
+// and ranking, see PathOptions.
+func FindMultiplePathsWithOptions(graph *Graph, start, end string, maxPaths int, opts PathOptions) [][]string {
+	c := Compile(graph)
+	s, okStart := c.ID(start)
+	e, okEnd := c.ID(end)
+	if !okStart || !okEnd {
+		return nil
+	}
+	return c.pathsNames(c.FindMultiplePathsWithOptions(s, e, maxPaths, opts))
+}
+
+// FindMultiplePaths is the greedy search on station IDs: one shortest path
+// through each neighbour of start, least connected neighbours first, with
+// the stations of every path removed once they are full. With other
+// PathOptions.Disjoint modes the tracks of every path are removed instead,
+// or nothing is.
+func (c *CompiledGraph) FindMultiplePaths(start, end, maxPaths int) [][]int {
+	return c.FindMultiplePathsWithOptions(start, end, maxPaths, PathOptions{})
+}
+
+// FindMultiplePathsWithOptions is FindMultiplePaths with a choice of costs
+// and ranking, see PathOptions.
+func (c *CompiledGraph) FindMultiplePathsWithOptions(start, end, maxPaths int, opts PathOptions) [][]int {
+	var paths [][]int
+	removed := make([]bool, len(c.Names))
+	removedArc := make([]bool, len(c.adj))
+	uses := make([]int, len(c.Names))
 
-	neighbors := graph.Connections[start]
+	// Neighbors is a view into the shared adjacency, which concurrent
+	// queries read, so sort a copy
+	neighbors := slices.Clone(c.Neighbors(start))
 	// Sort neighbors by number of connections
 	sort.Slice(neighbors, func(i, j int) bool {
-		return len(graph.Connections[neighbors[i]]) < len(graph.Connections[neighbors[j]])
+		return len(c.Neighbors(neighbors[i])) < len(c.Neighbors(neighbors[j]))
 	})
 
+	weighted := c.weighted()
 	for _, nbr := range neighbors {
 		if len(paths) >= maxPaths { // maxpaths == number of trains
 			break
 		}
-		pipe := bfsFromNeighbor(graph, start, nbr, end, removed)
+		var pipe []int
+		switch {
+		case opts.Geometric:
+			pipe = c.astarFromNeighbor(start, nbr, end, removed, removedArc)
+		case weighted:
+			pipe = c.dijkstraFromNeighbor(start, nbr, end, removed, removedArc)
+		default:
+			pipe = c.bfsFromNeighbor(start, nbr, end, removed, removedArc)
+		}
 		if len(pipe) == 0 {
 			continue
 		}
 		paths = append(paths, pipe)
-		for _, s := range pipe[1 : len(pipe)-1] {
-			removed[s] = true
+		switch opts.Disjoint {
+		case StationDisjoint:
+			// A station is removed once as many paths use it as it can hold trains
+			for _, s := range pipe[1 : len(pipe)-1] {
+				uses[s]++
+				if uses[s] >= c.Capacity(s) {
+					removed[s] = true
+				}
+			}
+		case EdgeDisjoint:
+			// Both directions of a two-way track
+			for i := 1; i < len(pipe); i++ {
+				arc := c.arc(pipe[i-1], pipe[i])
+				removedArc[arc] = true
+				if back := c.arc(pipe[i], pipe[i-1]); back >= 0 && !c.isOneWay(arc) {
+					removedArc[back] = true
+				}
+			}
 		}
 	}
 	// Sort by length of the path
-	sort.Slice(paths, func(i, j int) bool {
-		return len(paths[i]) < len(paths[j])
-	})
+	if opts.RankByLength {
+		sort.Slice(paths, func(i, j int) bool {
+			return c.PathLength(paths[i]) < c.PathLength(paths[j])
+		})
+	} else {
+		sort.Slice(paths, func(i, j int) bool {
+			return c.PathWeight(paths[i]) < c.PathWeight(paths[j])
+		})
+	}
 	return paths
 }
 
-func bfsFromNeighbor(graph *Graph, start, nbr, end string, removed map[string]bool) []string {
-	type state struct {
-		at   string
-		path []string
+// bfsFromNeighbor returns the path with the fewest hops from start through
+// nbr to end, avoiding removed stations and connections (by position in
+// adj). The start to nbr connection is never removed. Each station only
+// stores the station it was reached from, and the path is rebuilt once end
+// is found.
+func (c *CompiledGraph) bfsFromNeighbor(start, nbr, end int, removed, removedArc []bool) []int {
+	parent := make([]int, len(c.Names))
+	for i := range parent {
+		parent[i] = -1
 	}
-	q := []state{{nbr, []string{start, nbr}}}
-	seen := map[string]bool{start: true, nbr: true}
+	parent[start] = start
+	parent[nbr] = start
+	q := []int{nbr}
 
 	for len(q) > 0 {
 		current := q[0]
 		q = q[1:]
-	
-		if current.at == end {
-			return current.path
+
+		if current == end {
+			return c.walkBack(parent, start, end)
 		}
-	
-		for _, neighbor := range graph.Connections[current.at] {
-			if removed[neighbor] || seen[neighbor] {
+
+		for i := c.offsets[current]; i < c.offsets[current+1]; i++ {
+			neighbor := c.adj[i]
+			if removed[neighbor] || removedArc[i] || parent[neighbor] != -1 {
 				continue
 			}
-	
-			if current.at == start && neighbor != nbr {
+			parent[neighbor] = current
+			q = append(q, neighbor)
+		}
+	}
+	return nil
+}
+
+// dijkstraFromNeighbor is bfsFromNeighbor for weighted connections: it
+// returns the path through nbr that takes the fewest turns.
+func (c *CompiledGraph) dijkstraFromNeighbor(start, nbr, end int, removed, removedArc []bool) []int {
+	const unreached = -1
+	dist := make([]int, len(c.Names))
+	parent := make([]int, len(c.Names))
+	done := make([]bool, len(c.Names))
+	for i := range dist {
+		dist[i] = unreached
+		parent[i] = -1
+	}
+	done[start] = true
+	dist[nbr] = c.Weight(start, nbr)
+	parent[nbr] = start
+	q := stationQueue[int]{{nbr, dist[nbr]}}
+
+	for len(q) > 0 {
+		current := q.pop()
+		if done[current.id] {
+			continue
+		}
+		done[current.id] = true
+
+		if current.id == end {
+			return c.walkBack(parent, start, end)
+		}
+
+		for i := c.offsets[current.id]; i < c.offsets[current.id+1]; i++ {
+			neighbor := c.adj[i]
+			if removed[neighbor] || removedArc[i] || done[neighbor] {
 				continue
 			}
-	
-			seen[neighbor] = true
-	
-			newPath := append(append([]string{}, current.path...), neighbor)
-	
-			q = append(q, state{
-				at:   neighbor,
-				path: newPath,
-			})
+			d := current.dist + c.weights[i]
+			if dist[neighbor] == unreached || d < dist[neighbor] {
+				dist[neighbor] = d
+				parent[neighbor] = current.id
+				q.push(queuedStation[int]{neighbor, d})
+			}
 		}
 	}
 	return nil
-}
\ No newline at end of file
+}
+
+// walkBack rebuilds the path to end from parent pointers.
+func (c *CompiledGraph) walkBack(parent []int, start, end int) []int {
+	var path []int
+	for at := end; at != start; at = parent[at] {
+		path = append(path, at)
+	}
+	path = append(path, start)
+	slices.Reverse(path)
+	return path
+}
+
+// ---- Priority Queue ----
+
+// stationQueue is a binary min-heap on dist, in turns or in distance. It is
+// written out instead of using container/heap so pushes do not box every item.
+type queuedStation[D int | float64] struct {
+	id   int
+	dist D
+}
+
+type stationQueue[D int | float64] []queuedStation[D]
+
+func (q *stationQueue[D]) push(item queuedStation[D]) {
+	*q = append(*q, item)
+	h := *q
+	for i := len(h) - 1; i > 0; {
+		parent := (i - 1) / 2
+		if h[parent].dist <= h[i].dist {
+			break
+		}
+		h[parent], h[i] = h[i], h[parent]
+		i = parent
+	}
+}
+
+func (q *stationQueue[D]) pop() queuedStation[D] {
+	h := *q
+	top := h[0]
+	last := len(h) - 1
+	h[0] = h[last]
+	h = h[:last]
+	for i := 0; ; {
+		smallest := i
+		if l := 2*i + 1; l < len(h) && h[l].dist < h[smallest].dist {
+			smallest = l
+		}
+		if r := 2*i + 2; r < len(h) && h[r].dist < h[smallest].dist {
+			smallest = r
+		}
+		if smallest == i {
+			break
+		}
+		h[i], h[smallest] = h[smallest], h[i]
+		i = smallest
+	}
+	*q = h
+	return top
+}
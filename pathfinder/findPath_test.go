@@ -0,0 +1,103 @@
+package pathfinder
+
+import (
+	"strings"
+	"testing"
+)
+
+// Two lines meet at the interchange h, which holds one train.
+const bowTieMap = `stations:
+s,0,1
+a,1,0
+b,1,2
+h,2,1
+c,3,0
+d,3,2
+t,4,1
+
+connections:
+s-a
+s-b
+a-h
+b-h
+h-c
+h-d
+c-t
+d-t
+`
+
+func TestDisjointness(t *testing.T) {
+	g, err := ParseMap(strings.NewReader(bowTieMap))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		disjoint Disjointness
+		paths    int
+	}{
+		{StationDisjoint, 1},
+		{EdgeDisjoint, 2},
+		{NotDisjoint, 2},
+	}
+	for _, tc := range tests {
+		t.Run(tc.disjoint.String(), func(t *testing.T) {
+			opts := PathOptions{Disjoint: tc.disjoint}
+			greedy := FindMultiplePathsWithOptions(g, "s", "t", 4, opts)
+			optimal := FindOptimalPathsWithOptions(g, "s", "t", 4, opts)
+			if len(greedy) != tc.paths || len(optimal) != tc.paths {
+				t.Fatalf("got %d greedy and %d optimal paths, want %d: %v %v", len(greedy), len(optimal), tc.paths, greedy, optimal)
+			}
+			shared := false
+			tracks := make(map[string]bool)
+			for _, path := range optimal {
+				for i := 1; i < len(path); i++ {
+					key := normalizeEdgeKey(path[i-1], path[i])
+					shared = shared || tracks[key]
+					tracks[key] = true
+				}
+			}
+			if shared != (tc.disjoint == NotDisjoint) {
+				t.Errorf("paths sharing a track: %v", optimal)
+			}
+
+			// The simulator takes trains through h one at a time, and the
+			// turns returned are the ones it takes
+			paths, turns := FindFastestPathsWithOptions(g, "s", "t", 5, opts)
+			schedule := g.Simulate(g.AssignToPipelines(paths, 5))
+			for _, v := range ValidateSchedule(g, "s", "t", 5, schedule) {
+				t.Error(v)
+			}
+			if turns != schedule.TotalTurns {
+				t.Errorf("returned %d turns, simulated %d", turns, schedule.TotalTurns)
+			}
+		})
+	}
+
+	for _, s := range []string{"station", "edge", "none"} {
+		if d, err := ParseDisjointness(s); err != nil || d.String() != s {
+			t.Errorf("%q parsed as %v, %v", s, d, err)
+		}
+	}
+	if _, err := ParseDisjointness("track"); err == nil {
+		t.Error("expected an error for an unknown mode")
+	}
+}
+
+func TestCompileSkipsUnknownStations(t *testing.T) {
+	g := &Graph{
+		Stations: map[string]*Station{"a": {Name: "a"}, "b": {Name: "b", X: 1}},
+		Connections: map[string][]string{
+			"a": {"ghost", "b"},
+			"b": {"a"},
+		},
+	}
+	c := Compile(g)
+	a, _ := c.ID("a")
+	b, _ := c.ID("b")
+	if got := c.Neighbors(a); len(got) != 1 || got[0] != b {
+		t.Errorf("a has neighbours %v, want only b", got)
+	}
+	if paths := FindMultiplePaths(g, "a", "b", 2); len(paths) != 1 || len(paths[0]) != 2 {
+		t.Errorf("got %v, want [[a b]]", paths)
+	}
+}
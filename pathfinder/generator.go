@@ -3,186 +3,260 @@ package pathfinder
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"math/rand"
 	"os"
-	"strconv"
 	"strings"
+	"unicode"
 )
 
-// Generator creates a map file with random stations and connections
-func Generator() {
-
-	txtFile := os.Args[1]
-	mapFile := os.Args[2]
+// GenerateOptions controls GenerateMap. The same options always give the
+// same map.
+type GenerateOptions struct {
+	Seed     int64    // seed of the random source
+	Stations int      // number of stations, at least 2
+	Names    []string // station names, used in order after SanitizeName; missing ones are made up
+	Degree   int      // average connections per station, 4 if 0
+	Topology Topology // shape of the network, RandomTopology if 0
+	Radius   int      // PlanarTopology: connect stations at most this far apart instead of the Degree nearest
+}
 
-	// Check number of stations
-	numStations, err := strconv.Atoi(os.Args[3])
-	if err != nil || numStations < 2 {
-		exitWithError(fmt.Sprintf("Invalid number of stations: %s (must be at least 2)\n", os.Args[3]))
+// GenerateMap creates a connected map with random coordinates, or a grid,
+// and connections shaped by opts.Topology.
+func GenerateMap(opts GenerateOptions) (*Graph, error) {
+	if opts.Stations < 2 {
+		return nil, fmt.Errorf("invalid number of stations: %d (must be at least 2)", opts.Stations)
+	}
+	if opts.Degree < 0 {
+		return nil, fmt.Errorf("invalid degree: %d (must be positive)", opts.Degree)
+	}
+	if opts.Radius < 0 {
+		return nil, fmt.Errorf("invalid radius: %d (must be positive)", opts.Radius)
 	}
+	if opts.Topology < RandomTopology || opts.Topology > PlanarTopology {
+		return nil, fmt.Errorf("invalid topology: %d", opts.Topology)
+	}
+	rng := rand.New(rand.NewSource(opts.Seed))
 
-	// Get stations from the input file
-	stations, err := parseStations(txtFile, numStations)
-	if err != nil {
-		exitWithError(fmt.Sprintf("Error processing input file: %v\n", err))
+	stations := stationNames(opts.Names, opts.Stations)
+	var coords [][2]int
+	if opts.Topology == GridTopology {
+		coords = gridCoordinates(len(stations), 20)
+	} else {
+		coords = generateCoordinates(rng, stations)
 	}
+	degree := opts.Degree
+	if degree == 0 {
+		degree = 4
+	}
+	connections := generateConnections(rng, opts.Topology, coords, degree, opts.Radius)
+
+	return buildGeneratedGraph(stations, coords, connections), nil
+}
 
-	// Generate coordinates and connections
-	coords := generateCoordinates(stations, numStations)
-	connections := generateConnections(stations)
+// buildGeneratedGraph makes a graph of one-turn connections between
+// stations, given as pairs of indexes.
+func buildGeneratedGraph(stations []string, coords [][2]int, connections [][2]int) *Graph {
+	g := &Graph{
+		Stations:    make(map[string]*Station, len(stations)),
+		Connections: make(map[string][]string, len(stations)),
+		Weights:     make(map[string]int),
+		OneWay:      make(map[string]bool),
+		Comments:    make(map[string]Comment),
+	}
+	for i, name := range stations {
+		g.Stations[name] = &Station{name, coords[i][0], coords[i][1], 1}
+		g.stationOrder = append(g.stationOrder, name)
+	}
+	for _, c := range connections {
+		a, b := stations[c[0]], stations[c[1]]
+		g.Connections[a] = append(g.Connections[a], b)
+		g.Connections[b] = append(g.Connections[b], a)
+		g.connectionOrder = append(g.connectionOrder, connectionKey(a, b))
+	}
+	return g
+}
 
-	// Save results to file
-	err = saveToMapFile(mapFile, coords, connections)
+// WriteGeneratedMap generates a map and writes it as canonical .map text.
+func WriteGeneratedMap(w io.Writer, opts GenerateOptions) error {
+	g, err := GenerateMap(opts)
 	if err != nil {
-		exitWithError(fmt.Sprintf("Error saving .map file: %v\n", err))
+		return err
 	}
-
-	fmt.Printf(".map file successfully created: %s with %d stations.\n", mapFile, len(coords))
+	return WriteMap(w, g)
 }
 
-// Read TXT and extract name of stations, generate new if needed.
-func parseStations(filePath string, numStations int) ([]string, error) {
-	file, err := os.Open(filePath)
+// GenerateMapFile generates a map with names read from txtFile, or made-up
+// names if txtFile is "", and saves it to mapFile, adding the .map extension
+// if it is missing. It returns the name of the file written, once the file
+// is parsed back without errors.
+func GenerateMapFile(txtFile, mapFile string, opts GenerateOptions) (string, error) {
+	if txtFile != "" {
+		file, err := os.Open(txtFile)
+		if err != nil {
+			return "", fmt.Errorf("error processing input file: %w", err)
+		}
+		opts.Names, err = ReadNames(file)
+		file.Close()
+		if err != nil {
+			return "", fmt.Errorf("error processing input file: %w", err)
+		}
+	}
+
+	if !strings.HasSuffix(mapFile, ".map") {
+		mapFile += ".map"
+	}
+	out, err := os.Create(mapFile)
 	if err != nil {
-		return nil, err
+		return "", fmt.Errorf("error saving .map file: %w", err)
 	}
-	defer file.Close()
+	if err := WriteGeneratedMap(out, opts); err != nil {
+		out.Close()
+		return "", err
+	}
+	if err := out.Close(); err != nil {
+		return "", fmt.Errorf("error saving .map file: %w", err)
+	}
+	// Check what was written before reporting success
+	if _, err := ParseMapFileWithOptions(mapFile, ParseOptions{MaxStations: NoLimit}); err != nil {
+		return "", fmt.Errorf("generated map is invalid: %w", err)
+	}
+	return mapFile, nil
+}
 
+// ReadNames reads station names, one per line, skipping empty lines and
+// repeated names.
+func ReadNames(r io.Reader) ([]string, error) {
 	unique := make(map[string]struct{})
-	var stations []string
-	scanner := bufio.NewScanner(file)
+	var names []string
+	scanner := bufio.NewScanner(r)
 	for scanner.Scan() {
 		name := scanner.Text()
 		if name != "" {
 			if _, exists := unique[name]; !exists {
-				stations = append(stations, name)
+				names = append(names, name)
 				unique[name] = struct{}{}
 			}
 		}
 	}
-	if err := scanner.Err(); err != nil {
-		return nil, err
-	}
-	for len(stations) < numStations {
-		newName := fmt.Sprintf("Station%d", len(stations)+1)
-		if _, exists := unique[newName]; !exists {
-			stations = append(stations, newName)
-			unique[newName] = struct{}{}
-		}
-	}
-	if len(stations) > numStations {
-		stations = stations[:numStations]
-	}
-	return stations, nil
+	return names, scanner.Err()
 }
 
-// Generate random coordinates, avoiding duplicates.
-func generateCoordinates(stations []string, numStations int) map[string][2]int {
-	coords := make(map[string][2]int)
-	used := make(map[string]struct{})
-
-	for _, station := range stations {
-		for {
-			x, y := rand.Intn(numStations*20), rand.Intn(numStations*20)
-			coordKey := fmt.Sprintf("%d,%d", x, y)
-			if _, exists := used[coordKey]; !exists {
-				coords[station] = [2]int{x, y}
-				used[coordKey] = struct{}{}
+// stationNames takes the first numStations names as valid identifiers (see
+// SanitizeName), making up more if needed. A name that is taken gets a
+// number: a second "la_crau" becomes "la_crau_2". A name with nothing left
+// once sanitised, such as "Москва", is made up as "station_N" in its place.
+func stationNames(names []string, numStations int) []string {
+	unique := make(map[string]struct{}, numStations)
+	stations := make([]string, 0, numStations)
+	use := func(name string) {
+		if name == "" {
+			return
+		}
+		candidate := name
+		for n := 2; ; n++ {
+			if _, exists := unique[candidate]; !exists {
 				break
 			}
+			candidate = fmt.Sprintf("%s_%d", name, n)
 		}
+		stations = append(stations, candidate)
+		unique[candidate] = struct{}{}
 	}
-	return coords
-}
-
-// Check for connection in the list (including reverse ones)
-func isDuplicateConnection(connections []string, a, b string) bool {
-	connectionAB := fmt.Sprintf("%s-%s", a, b)
-	connectionBA := fmt.Sprintf("%s-%s", b, a)
-
-	for _, conn := range connections {
-		if conn == connectionAB || conn == connectionBA {
-			return true
+	for _, name := range names {
+		if len(stations) == numStations {
+			break
+		}
+		name = SanitizeName(name)
+		if name == "" {
+			name = fmt.Sprintf("station_%d", len(stations)+1)
 		}
+		use(name)
 	}
-	return false
+	for n := len(stations) + 1; len(stations) < numStations; n++ {
+		use(fmt.Sprintf("station_%d", n))
+	}
+	return stations
 }
 
-// Generate unique connections between stations
-func generateConnections(stations []string) []string {
-	// Number of desired connections
-	targetConnections := len(stations) * 2
-	connections := make([]string, 0)
-
-	// Limit the number of attempts to avoid infinite loop
-	maxAttempts := targetConnections * 10
-	attempts := 0
-
-	// Use an iterator to ensure minimum connection for each station
-	for i := 0; i < len(stations) && len(connections) < targetConnections; i++ {
-		// Guarantee that each station has at least one connection
-		connected := false
-		for j := 0; j < 3 && !connected; j++ { // Try up to 3 times for each station
-			b := rand.Intn(len(stations))
-			if i != b && !isDuplicateConnection(connections, stations[i], stations[b]) {
-				connections = append(connections, fmt.Sprintf("%s-%s", stations[i], stations[b]))
-				connected = true
-			}
+// SanitizeName turns any text into a valid station name: accents are
+// dropped, letters are lowercased, and every other run of characters
+// becomes one underscore, so "Besançon Viotte" becomes "besancon_viotte".
+// Text without Latin letters or digits gives "".
+func SanitizeName(name string) string {
+	var b strings.Builder
+	gap := false
+	for _, r := range name {
+		if base, ok := accents[r]; ok {
+			r = base
 		}
-	}
-
-	// Add remaining connections randomly
-	for len(connections) < targetConnections && attempts < maxAttempts {
-		a := rand.Intn(len(stations))
-		b := rand.Intn(len(stations))
-		if a != b && !isDuplicateConnection(connections, stations[a], stations[b]) {
-			connections = append(connections, fmt.Sprintf("%s-%s", stations[a], stations[b]))
+		r = unicode.ToLower(r)
+		letters, ok := ligatures[r]
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			letters, ok = string(r), true
+		}
+		if !ok {
+			gap = true
+			continue
+		}
+		if gap && b.Len() > 0 {
+			b.WriteByte('_')
 		}
-		attempts++
+		b.WriteString(letters)
+		gap = false
 	}
-
-	return connections
+	return b.String()
 }
 
-// Save to `.map` file.
-func saveToMapFile(filename string, stations map[string][2]int, connections []string) error {
-	if !strings.HasSuffix(filename, ".map") {
-		filename += ".map"
-	}
-
-	file, err := os.Create(filename)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	// Write stations section
-	if _, err := file.WriteString("stations:\n"); err != nil {
-		return err
-	}
+// ligatures maps letters written as two Latin letters.
+var ligatures = map[rune]string{'æ': "ae", 'œ': "oe", 'ß': "ss"}
 
-	for name, coord := range stations {
-		line := fmt.Sprintf("%s,%d,%d\n", name, coord[0], coord[1])
-		if _, err := file.WriteString(line); err != nil {
-			return err
+// accents maps accented Latin letters to the letter without the accent.
+var accents = func() map[rune]rune {
+	m := make(map[rune]rune)
+	for base, letters := range map[rune]string{
+		'a': "àáâãäåāăąǎ", 'A': "ÀÁÂÃÄÅĀĂĄǍ",
+		'c': "çćĉċč", 'C': "ÇĆĈĊČ",
+		'd': "ďđ", 'D': "ĎĐ",
+		'e': "èéêëēĕėęě", 'E': "ÈÉÊËĒĔĖĘĚ",
+		'g': "ĝğġģ", 'G': "ĜĞĠĢ",
+		'h': "ĥħ", 'H': "ĤĦ",
+		'i': "ìíîïĩīĭįı", 'I': "ÌÍÎÏĨĪĬĮİ",
+		'j': "ĵ", 'J': "Ĵ",
+		'k': "ķ", 'K': "Ķ",
+		'l': "ĺļľŀł", 'L': "ĹĻĽĿŁ",
+		'n': "ñńņňŉ", 'N': "ÑŃŅŇ",
+		'o': "òóôõöøōŏőǒ", 'O': "ÒÓÔÕÖØŌŎŐǑ",
+		'r': "ŕŗř", 'R': "ŔŖŘ",
+		's': "śŝşšș", 'S': "ŚŜŞŠȘ",
+		't': "ţťŧț", 'T': "ŢŤŦȚ",
+		'u': "ùúûüũūŭůűųǔ", 'U': "ÙÚÛÜŨŪŬŮŰŲǓ",
+		'w': "ŵ", 'W': "Ŵ",
+		'y': "ýÿŷ", 'Y': "ÝŸŶ",
+		'z': "źżž", 'Z': "ŹŻŽ",
+	} {
+		for _, r := range letters {
+			m[r] = base
 		}
 	}
+	return m
+}()
 
-	// Write connections section
-	if _, err := file.WriteString("\nconnections:\n"); err != nil {
-		return err
-	}
+// Generate random coordinates, avoiding duplicates.
+func generateCoordinates(rng *rand.Rand, stations []string) [][2]int {
+	coords := make([][2]int, len(stations))
+	used := make(map[[2]int]struct{}, len(stations))
+	size := len(stations) * 20
 
-	for _, connection := range connections {
-		if _, err := file.WriteString(connection + "\n"); err != nil {
-			return err
+	for i := range stations {
+		for {
+			coord := [2]int{rng.Intn(size), rng.Intn(size)}
+			if _, exists := used[coord]; !exists {
+				coords[i] = coord
+				used[coord] = struct{}{}
+				break
+			}
 		}
 	}
-
-	return nil
+	return coords
 }
-
-func exitWithError(msg string) {
-	fmt.Fprintln(os.Stderr, red+"Error: "+reset, yellow+msg+reset)
-	os.Exit(1)
-}
\ No newline at end of file
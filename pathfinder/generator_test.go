@@ -0,0 +1,227 @@
+package pathfinder
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestGenerateMapIsReproducible(t *testing.T) {
+	names := make([]string, 50)
+	for i := range names {
+		names[i] = fmt.Sprintf("s%d", i)
+	}
+	generate := func(seed int64) string {
+		var buf bytes.Buffer
+		if err := WriteGeneratedMap(&buf, GenerateOptions{Seed: seed, Stations: 50, Names: names, Degree: 6}); err != nil {
+			t.Fatal(err)
+		}
+		return buf.String()
+	}
+
+	first := generate(7)
+	if again := generate(7); again != first {
+		t.Error("the same seed gave two different maps")
+	}
+	if other := generate(8); other == first {
+		t.Error("different seeds gave the same map")
+	}
+
+	g, err := ParseMap(strings.NewReader(first))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g.Stations) != 50 {
+		t.Errorf("got %d stations, want 50", len(g.Stations))
+	}
+	if got := len(g.connectionOrder); got != 150 {
+		t.Errorf("got %d connections, want 150 for degree 6", got)
+	}
+
+	if _, err := GenerateMap(GenerateOptions{Stations: 1}); err == nil {
+		t.Error("expected an error for one station")
+	}
+}
+
+func TestReadNames(t *testing.T) {
+	names, err := ReadNames(strings.NewReader("a\n\nb\na\nc\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(names, ",") != "a,b,c" {
+		t.Errorf("got %v", names)
+	}
+}
+
+func TestSanitizeName(t *testing.T) {
+	tests := map[string]string{
+		"Besançon Viotte":     "besancon_viotte",
+		"Köln Hbf":            "koln_hbf",
+		"  Saint-Étienne  ":   "saint_etienne",
+		"Station12":           "station12",
+		"Łódź Fabryczna":      "lodz_fabryczna",
+		"Straße (Nord) / Süd": "strasse_nord_sud",
+		"Æbeltoft":            "aebeltoft",
+		"Saint Œuf":           "saint_oeuf",
+		"Foo Ærø":             "foo_aero",
+		"Große Straße":        "grosse_strasse",
+		"already_valid_name":  "already_valid_name",
+		"東京":                  "",
+		"Москва":              "",
+		"!!!":                 "",
+	}
+	for in, want := range tests {
+		if got := SanitizeName(in); got != want {
+			t.Errorf("SanitizeName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestGeneratedNamesAreValid(t *testing.T) {
+	names := []string{"Besançon Viotte", "besancon viotte", "BESANÇON-VIOTTE", "東京", "Station2", "La Crau", "Москва"}
+	g, err := GenerateMap(GenerateOptions{Seed: 1, Stations: 8, Names: names})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"besancon_viotte", "besancon_viotte_2", "besancon_viotte_3", "station_4", "station2", "la_crau", "station_7", "station_8"}
+	if strings.Join(g.stationOrder, ",") != strings.Join(want, ",") {
+		t.Errorf("got %v, want %v", g.stationOrder, want)
+	}
+
+	mapFile, err := GenerateMapFile("../stations.txt", t.TempDir()+"/generated", GenerateOptions{Seed: 3, Stations: 200})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseMapFile(mapFile); err != nil {
+		t.Errorf("%s does not parse: %v", mapFile, err)
+	}
+}
+
+func TestTopologiesAreConnected(t *testing.T) {
+	for topology := RandomTopology; topology <= PlanarTopology; topology++ {
+		t.Run(topology.String(), func(t *testing.T) {
+			for seed := int64(0); seed < 20; seed++ {
+				opts := GenerateOptions{Seed: seed, Stations: 60, Degree: 2, Topology: topology}
+				g, err := GenerateMap(opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				reached := map[string]bool{g.stationOrder[0]: true}
+				queue := []string{g.stationOrder[0]}
+				for len(queue) > 0 {
+					for _, nbr := range g.Connections[queue[0]] {
+						if !reached[nbr] {
+							reached[nbr] = true
+							queue = append(queue, nbr)
+						}
+					}
+					queue = queue[1:]
+				}
+				if len(reached) != len(g.Stations) {
+					t.Fatalf("seed %d: %d of %d stations connected", seed, len(reached), len(g.Stations))
+				}
+
+				var a, b bytes.Buffer
+				WriteMap(&a, g)
+				if err := WriteGeneratedMap(&b, opts); err != nil || a.String() != b.String() {
+					t.Fatalf("seed %d: the same seed gave two different maps (%v)", seed, err)
+				}
+			}
+
+			g, err := GenerateMap(GenerateOptions{Seed: 1, Stations: 400, Topology: topology})
+			if err != nil {
+				t.Fatal(err)
+			}
+			most := 0
+			for _, name := range g.stationOrder {
+				most = max(most, len(g.Connections[name]))
+			}
+			switch topology {
+			case GridTopology:
+				if most > 4 || len(g.connectionOrder) != 2*20*19 {
+					t.Errorf("grid of 400 has %d connections and a station with %d", len(g.connectionOrder), most)
+				}
+			case ScaleFreeTopology:
+				if most < 20 {
+					t.Errorf("expected a hub, the busiest station has %d connections", most)
+				}
+			}
+		})
+	}
+
+	for _, s := range []string{"random", "grid", "geometric", "small-world", "scale-free", "planar"} {
+		if topology, err := ParseTopology(s); err != nil || topology.String() != s {
+			t.Errorf("%q parsed as %q (%v)", s, topology, err)
+		}
+	}
+	if _, err := ParseTopology("ring"); err == nil {
+		t.Error("expected an error for an unknown topology")
+	}
+}
+
+func TestPlanarMapsDoNotCross(t *testing.T) {
+	for _, opts := range []GenerateOptions{
+		{Seed: 1, Stations: 300, Topology: PlanarTopology},
+		{Seed: 2, Stations: 300, Topology: PlanarTopology, Degree: 8},
+		{Seed: 3, Stations: 300, Topology: PlanarTopology, Radius: 400},
+		{Seed: 4, Stations: 300, Topology: PlanarTopology, Radius: 1}, // only joins
+	} {
+		g, err := GenerateMap(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var tracks [][2][2]int
+		for _, key := range g.connectionOrder {
+			a, b, _ := strings.Cut(key, "-")
+			tracks = append(tracks, [2][2]int{
+				{g.Stations[a].X, g.Stations[a].Y},
+				{g.Stations[b].X, g.Stations[b].Y},
+			})
+		}
+		for i := range tracks {
+			for j := i + 1; j < len(tracks); j++ {
+				if segmentsCross(tracks[i][0], tracks[i][1], tracks[j][0], tracks[j][1]) {
+					t.Fatalf("%+v: %s crosses %s", opts, g.connectionOrder[i], g.connectionOrder[j])
+				}
+			}
+		}
+
+		// Nearby only: shorter on average than random connections
+		planar := 0.0
+		for _, track := range tracks {
+			planar += distance(track[0], track[1])
+		}
+		random, err := GenerateMap(GenerateOptions{Seed: opts.Seed, Stations: opts.Stations})
+		if err != nil {
+			t.Fatal(err)
+		}
+		far := 0.0
+		for _, key := range random.connectionOrder {
+			a, b, _ := strings.Cut(key, "-")
+			far += distance([2]int{random.Stations[a].X, random.Stations[a].Y}, [2]int{random.Stations[b].X, random.Stations[b].Y})
+		}
+		if planar/float64(len(tracks))*4 > far/float64(len(random.connectionOrder)) {
+			t.Errorf("%+v: average connection %.0f long, random maps %.0f", opts, planar/float64(len(tracks)), far/float64(len(random.connectionOrder)))
+		}
+	}
+}
+
+func TestSegmentsCross(t *testing.T) {
+	tests := []struct {
+		p1, p2, q1, q2 [2]int
+		cross          bool
+	}{
+		{[2]int{0, 0}, [2]int{4, 4}, [2]int{0, 4}, [2]int{4, 0}, true},  // X
+		{[2]int{0, 0}, [2]int{4, 0}, [2]int{0, 1}, [2]int{4, 1}, false}, // parallel
+		{[2]int{0, 0}, [2]int{4, 0}, [2]int{4, 0}, [2]int{4, 4}, false}, // shared station
+		{[2]int{0, 0}, [2]int{4, 0}, [2]int{0, 0}, [2]int{2, 0}, true},  // along each other
+		{[2]int{0, 0}, [2]int{4, 0}, [2]int{2, 0}, [2]int{2, 3}, true},  // through a station
+		{[2]int{0, 0}, [2]int{2, 0}, [2]int{3, 0}, [2]int{5, 0}, false}, // in line, apart
+	}
+	for _, tc := range tests {
+		if got := segmentsCross(tc.p1, tc.p2, tc.q1, tc.q2); got != tc.cross {
+			t.Errorf("%v-%v and %v-%v: cross %v, want %v", tc.p1, tc.p2, tc.q1, tc.q2, got, tc.cross)
+		}
+	}
+}
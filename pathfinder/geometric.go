@@ -0,0 +1,88 @@
+package pathfinder
+
+import (
+	"math"
+)
+
+// ---- Geometric Costs ----
+
+// Distance returns the straight-line distance between two stations, 0 if
+// either does not exist.
+func (g *Graph) Distance(a, b string) float64 {
+	if g == nil {
+		return 0
+	}
+	sa, okA := g.Stations[a]
+	sb, okB := g.Stations[b]
+	if !okA || !okB {
+		return 0
+	}
+	return math.Hypot(float64(sa.X-sb.X), float64(sa.Y-sb.Y))
+}
+
+// PathLength returns the total straight-line length of the path.
+func (g *Graph) PathLength(path []string) float64 {
+	total := 0.0
+	for i := 1; i < len(path); i++ {
+		total += g.Distance(path[i-1], path[i])
+	}
+	return total
+}
+
+// Distance returns the straight-line distance between stations u and v.
+func (c *CompiledGraph) Distance(u, v int) float64 {
+	return math.Hypot(c.x[u]-c.x[v], c.y[u]-c.y[v])
+}
+
+// PathLength returns the total straight-line length of the path.
+func (c *CompiledGraph) PathLength(path []int) float64 {
+	total := 0.0
+	for i := 1; i < len(path); i++ {
+		total += c.Distance(path[i-1], path[i])
+	}
+	return total
+}
+
+// astarFromNeighbor is dijkstraFromNeighbor with distances as costs: it
+// returns the physically shortest path through nbr. Stations are taken in
+// order of distance so far plus the straight line to end, which never
+// overestimates, so the first time end is taken the path is shortest.
+func (c *CompiledGraph) astarFromNeighbor(start, nbr, end int, removed, removedArc []bool) []int {
+	dist := make([]float64, len(c.Names))
+	parent := make([]int, len(c.Names))
+	done := make([]bool, len(c.Names))
+	for i := range dist {
+		dist[i] = math.Inf(1)
+		parent[i] = -1
+	}
+	done[start] = true
+	dist[nbr] = c.Distance(start, nbr)
+	parent[nbr] = start
+	q := stationQueue[float64]{{nbr, dist[nbr] + c.Distance(nbr, end)}}
+
+	for len(q) > 0 {
+		current := q.pop()
+		if done[current.id] {
+			continue
+		}
+		done[current.id] = true
+
+		if current.id == end {
+			return c.walkBack(parent, start, end)
+		}
+
+		for i := c.offsets[current.id]; i < c.offsets[current.id+1]; i++ {
+			neighbor := c.adj[i]
+			if removed[neighbor] || removedArc[i] || done[neighbor] {
+				continue
+			}
+			d := dist[current.id] + c.Distance(current.id, neighbor)
+			if d < dist[neighbor] {
+				dist[neighbor] = d
+				parent[neighbor] = current.id
+				q.push(queuedStation[float64]{neighbor, d + c.Distance(neighbor, end)})
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package pathfinder
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// From a through c the fewest hops go over the hill at b, the shortest
+// track runs along the valley through d and e. y, searched after c as it
+// has more connections, is the other way over the hill.
+const valleyMap = `stations:
+a,0,0
+c,1,0
+b,2,10
+d,2,0
+e,3,0
+z,4,0
+y,0,9
+p,0,20
+q,1,20
+
+connections:
+a-c
+c-b
+b-z
+c-d
+d-e
+e-z
+a-y
+y-b
+y-p
+y-q
+`
+
+func TestGeometricSearch(t *testing.T) {
+	g, err := ParseMap(strings.NewReader(valleyMap))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := g.Distance("a", "y"); d != 9 {
+		t.Errorf("distance a-y %v, want 9", d)
+	}
+	if l := g.PathLength([]string{"a", "c", "d", "e", "z"}); math.Abs(l-4) > 1e-9 {
+		t.Errorf("valley length %v, want 4", l)
+	}
+
+	hops := FindMultiplePaths(g, "a", "z", 1)
+	geometric := FindMultiplePathsWithOptions(g, "a", "z", 1, PathOptions{Geometric: true})
+	if want := [][]string{{"a", "c", "b", "z"}}; !reflect.DeepEqual(hops, want) {
+		t.Errorf("by hops got %v, want %v", hops, want)
+	}
+	if want := [][]string{{"a", "c", "d", "e", "z"}}; !reflect.DeepEqual(geometric, want) {
+		t.Errorf("geometric got %v, want %v", geometric, want)
+	}
+
+	// Both neighbours of a give a path; ranking decides which comes first
+	byTurns := FindMultiplePathsWithOptions(g, "a", "z", 2, PathOptions{Geometric: true})
+	byLength := FindMultiplePathsWithOptions(g, "a", "z", 2, PathOptions{Geometric: true, RankByLength: true})
+	if len(byTurns) != 2 || len(byLength) != 2 {
+		t.Fatalf("got %v and %v, want two paths each", byTurns, byLength)
+	}
+	if byTurns[0][1] != "y" || byLength[0][1] != "c" {
+		t.Errorf("by turns %v, by length %v", byTurns, byLength)
+	}
+}
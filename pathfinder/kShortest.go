@@ -0,0 +1,136 @@
+package pathfinder
+
+import (
+	"slices"
+)
+
+// ---- K Shortest Paths ----
+
+// KShortestPaths returns up to k simple paths from start to end in order of
+// the turns they take, using Yen's algorithm. Unlike FindMultiplePaths the
+// paths may share stations and connections, so they are alternatives to
+// choose from rather than a set to run at once; fed to AssignToPipelines,
+// the simulator keeps trains on shared stations from colliding.
+// Paths taking the same turns come fewest stations first.
+func KShortestPaths(graph *Graph, start, end string, k int) [][]string {
+	c := Compile(graph)
+	s, okStart := c.ID(start)
+	e, okEnd := c.ID(end)
+	if !okStart || !okEnd {
+		return nil
+	}
+	return c.pathsNames(c.KShortestPaths(s, e, k))
+}
+
+// KShortestPaths is KShortestPaths on station IDs.
+func (c *CompiledGraph) KShortestPaths(start, end, k int) [][]int {
+	if k <= 0 || start == end {
+		return nil
+	}
+	removed := make([]bool, len(c.Names))
+	removedArc := make([]bool, len(c.adj))
+
+	first := c.shortestPath(start, end, removed, removedArc)
+	if first == nil {
+		return nil
+	}
+	paths := [][]int{first}
+	var candidates [][]int
+
+	for len(paths) < k {
+		last := paths[len(paths)-1]
+		// Every station but the last can be where a new path leaves the last one
+		for i := 0; i < len(last)-1; i++ {
+			spur, root := last[i], last[:i+1]
+
+			// Leave root differently from every path found with the same root
+			var arcs []int
+			for _, p := range paths {
+				if len(p) > i+1 && slices.Equal(p[:i+1], root) {
+					arcs = append(arcs, c.arc(p[i], p[i+1]))
+					removedArc[arcs[len(arcs)-1]] = true
+				}
+			}
+			for _, s := range root[:i] {
+				removed[s] = true
+			}
+
+			if tail := c.shortestPath(spur, end, removed, removedArc); tail != nil {
+				path := append(slices.Clone(root[:i]), tail...)
+				if !slices.ContainsFunc(candidates, func(p []int) bool { return slices.Equal(p, path) }) {
+					candidates = append(candidates, path)
+				}
+			}
+
+			for _, a := range arcs {
+				removedArc[a] = false
+			}
+			for _, s := range root[:i] {
+				removed[s] = false
+			}
+		}
+		if len(candidates) == 0 {
+			break
+		}
+
+		best := 0
+		for i, p := range candidates {
+			if c.shorterPath(p, candidates[best]) {
+				best = i
+			}
+		}
+		paths = append(paths, candidates[best])
+		candidates = slices.Delete(candidates, best, best+1)
+	}
+	return paths
+}
+
+// shorterPath orders paths by turns, then by number of stations.
+func (c *CompiledGraph) shorterPath(a, b []int) bool {
+	wa, wb := c.PathWeight(a), c.PathWeight(b)
+	if wa != wb {
+		return wa < wb
+	}
+	return len(a) < len(b)
+}
+
+// shortestPath returns the path from start to end taking the fewest turns
+// without the removed stations and connections, or nil if there is none.
+func (c *CompiledGraph) shortestPath(start, end int, removed, removedArc []bool) []int {
+	const unreached = -1
+	dist := make([]int, len(c.Names))
+	parent := make([]int, len(c.Names))
+	done := make([]bool, len(c.Names))
+	for i := range dist {
+		dist[i] = unreached
+		parent[i] = -1
+	}
+	dist[start] = 0
+	q := stationQueue[int]{{start, 0}}
+
+	for len(q) > 0 {
+		current := q.pop()
+		if done[current.id] {
+			continue
+		}
+		done[current.id] = true
+
+		if current.id == end {
+			return c.walkBack(parent, start, end)
+		}
+
+		for i := c.offsets[current.id]; i < c.offsets[current.id+1]; i++ {
+			neighbor := c.adj[i]
+			if removed[neighbor] || removedArc[i] || done[neighbor] {
+				continue
+			}
+			d := current.dist + c.weights[i]
+			if dist[neighbor] == unreached || d < dist[neighbor] {
+				dist[neighbor] = d
+				parent[neighbor] = current.id
+				q.push(queuedStation[int]{neighbor, d})
+			}
+		}
+	}
+	return nil
+}
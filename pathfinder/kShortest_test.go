@@ -0,0 +1,107 @@
+package pathfinder
+
+import (
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// allSimplePaths lists every simple path from start to end, depth first.
+func allSimplePaths(g *Graph, start, end string) [][]string {
+	var paths [][]string
+	var walk func(path []string)
+	walk = func(path []string) {
+		at := path[len(path)-1]
+		if at == end {
+			paths = append(paths, slices.Clone(path))
+			return
+		}
+		for _, next := range g.Connections[at] {
+			if !slices.Contains(path, next) {
+				walk(append(path, next))
+			}
+		}
+	}
+	walk([]string{start})
+	return paths
+}
+
+func TestKShortestPaths(t *testing.T) {
+	for _, tc := range scheduleCases {
+		t.Run(tc.mapFile, func(t *testing.T) {
+			g, err := ParseMapFile("../testdata/" + tc.mapFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			all := allSimplePaths(g, tc.start, tc.end)
+			sort.SliceStable(all, func(i, j int) bool { return g.PathWeight(all[i]) < g.PathWeight(all[j]) })
+
+			k := min(len(all), 8)
+			paths := KShortestPaths(g, tc.start, tc.end, k)
+			if len(paths) != k {
+				t.Fatalf("got %d paths, want %d", len(paths), k)
+			}
+			for i, path := range paths {
+				if !slices.ContainsFunc(all, func(p []string) bool { return slices.Equal(p, path) }) {
+					t.Errorf("path %d %v is not a simple path", i, path)
+				}
+				if got, want := g.PathWeight(path), g.PathWeight(all[i]); got != want {
+					t.Errorf("path %d takes %d turns, want %d", i, got, want)
+				}
+				for _, other := range paths[:i] {
+					if slices.Equal(other, path) {
+						t.Errorf("path %d %v is listed twice", i, path)
+					}
+				}
+			}
+			if more := KShortestPaths(g, tc.start, tc.end, len(all)+5); len(more) != len(all) {
+				t.Errorf("asking for more than exist gave %d paths, want %d", len(more), len(all))
+			}
+
+			// Shared stations are left to the simulator
+			schedule := g.Simulate(g.AssignToPipelines(paths, tc.trains))
+			for _, v := range ValidateSchedule(g, tc.start, tc.end, tc.trains, schedule) {
+				t.Error(v)
+			}
+		})
+	}
+}
+
+// Weighted tracks and paths crossing s2 and s5 in both orders: trains still
+// on their first connection hold the stations ahead of them too.
+const crossingWeightedMap = `stations:
+s0,0,0
+s1,1,0
+s2,2,0
+s3,3,0
+s4,4,0
+s5,5,0
+
+connections:
+s0-s2,2
+s0-s5,2
+s1-s4,1
+s1-s5,2
+s2-s3
+s2-s5,3
+s3-s4,2
+`
+
+func TestKShortestPathsOnWeightedMap(t *testing.T) {
+	g, err := ParseMap(strings.NewReader(crossingWeightedMap))
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := KShortestPaths(g, "s0", "s1", 4)
+	if len(paths) != 4 {
+		t.Fatalf("got %d paths, want 4", len(paths))
+	}
+	schedule := g.Simulate(g.AssignToPipelines(paths, 7))
+	if schedule.Arrived != 7 {
+		t.Errorf("%d of 7 trains arrived", schedule.Arrived)
+	}
+	for _, v := range ValidateSchedule(g, "s0", "s1", 7, schedule) {
+		t.Error(v)
+	}
+}
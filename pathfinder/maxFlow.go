@@ -0,0 +1,331 @@
+package pathfinder
+
+import (
+	"math"
+	"sort"
+)
+
+// ---- Flow Network ----
+
+// flowNetwork is a residual graph used for node-split min-cost max-flow.
+// Every station v is split into in(v)=2v and out(v)=2v+1 joined by an arc
+// whose capacity is Station.Capacity, so at most that many paths pass
+// through v: one for ordinary stations, more for stations that hold several
+// trains.
+type flowNetwork struct {
+	graph  *CompiledGraph
+	head   []int
+	next   []int
+	to     []int
+	cap    []int
+	flow   []int
+	cost   []int
+	source int
+	sink   int
+
+	potential []int
+
+	// Reused by every augment
+	dist  []int
+	via   []int
+	done  []bool
+	queue stationQueue[int]
+}
+
+func (f *flowNetwork) addArc(u, v, capacity, cost int) {
+	// Forward arc
+	f.to = append(f.to, v)
+	f.cap = append(f.cap, capacity)
+	f.flow = append(f.flow, 0)
+	f.cost = append(f.cost, cost)
+	f.next = append(f.next, f.head[u])
+	f.head[u] = len(f.to) - 1
+	// Residual arc
+	f.to = append(f.to, u)
+	f.cap = append(f.cap, 0)
+	f.flow = append(f.flow, 0)
+	f.cost = append(f.cost, -cost)
+	f.next = append(f.next, f.head[v])
+	f.head[v] = len(f.to) - 1
+}
+
+// newFlowNetwork builds the network for paths between start and end. Unless
+// disjoint is StationDisjoint, station arcs do not limit the flow, so only
+// tracks are kept apart.
+func newFlowNetwork(c *CompiledGraph, start, end int, disjoint Disjointness) *flowNetwork {
+	n := 2 * len(c.Names)
+	arcs := 2 * (len(c.Names) + len(c.adj))
+	f := &flowNetwork{
+		graph:     c,
+		head:      make([]int, n),
+		next:      make([]int, 0, arcs),
+		to:        make([]int, 0, arcs),
+		cap:       make([]int, 0, arcs),
+		flow:      make([]int, 0, arcs),
+		cost:      make([]int, 0, arcs),
+		potential: make([]int, n),
+		dist:      make([]int, n),
+		via:       make([]int, n),
+		done:      make([]bool, n),
+	}
+	for i := range f.head {
+		f.head[i] = -1
+	}
+
+	for v := range c.Names {
+		capacity := c.Capacity(v)
+		if v == start || v == end || disjoint != StationDisjoint {
+			capacity = len(c.Names)
+		}
+		f.addArc(2*v, 2*v+1, capacity, 0)
+	}
+	for v := range c.Names {
+		for i := c.offsets[v]; i < c.offsets[v+1]; i++ {
+			f.addArc(2*v+1, 2*c.adj[i], 1, c.weights[i])
+		}
+	}
+
+	f.source = 2*start + 1
+	f.sink = 2 * end
+	return f
+}
+
+// augment pushes one more unit of flow along the cheapest residual path.
+// Earlier paths can be rerouted through residual arcs. Returns false when
+// no augmenting path is left.
+func (f *flowNetwork) augment() bool {
+	const inf = int(^uint(0) >> 1)
+	dist, via, done := f.dist, f.via, f.done
+	for i := range dist {
+		dist[i] = inf
+		via[i] = -1
+		done[i] = false
+	}
+
+	// Dijkstra on reduced costs cost+potential[u]-potential[v], which stay
+	// non-negative even though residual arcs carry negative costs
+	dist[f.source] = 0
+	q := f.queue[:0]
+	q.push(queuedStation[int]{f.source, 0})
+	for len(q) > 0 {
+		current := q.pop()
+		u := current.id
+		if done[u] {
+			continue
+		}
+		done[u] = true
+		if u == f.sink {
+			break
+		}
+		for e := f.head[u]; e != -1; e = f.next[e] {
+			if f.cap[e]-f.flow[e] <= 0 {
+				continue
+			}
+			v := f.to[e]
+			d := dist[u] + f.cost[e] + f.potential[u] - f.potential[v]
+			if d < dist[v] {
+				dist[v] = d
+				via[v] = e
+				q.push(queuedStation[int]{v, d})
+			}
+		}
+	}
+	f.queue = q
+	if dist[f.sink] == inf {
+		return false
+	}
+	// Stations not settled before the sink are at least as far as the sink
+	for v := range dist {
+		if done[v] {
+			f.potential[v] += dist[v]
+		} else {
+			f.potential[v] += dist[f.sink]
+		}
+	}
+
+	for v := f.sink; v != f.source; v = f.to[via[v]^1] {
+		e := via[v]
+		f.flow[e]++
+		f.flow[e^1]--
+	}
+	return true
+}
+
+// paths decomposes the current flow into station paths, shortest first.
+func (f *flowNetwork) paths() [][]int {
+	used := make([]int, len(f.to))
+	var paths [][]int
+
+	for {
+		path := []int{f.source / 2}
+		u := f.source
+		for u != f.sink {
+			found := -1
+			for e := f.head[u]; e != -1; e = f.next[e] {
+				if e%2 == 0 && f.flow[e]-used[e] > 0 {
+					found = e
+					break
+				}
+			}
+			if found == -1 {
+				break
+			}
+			used[found]++
+			u = f.to[found]
+			// Step over the in->out arc of intermediate stations
+			if u != f.sink {
+				path = append(path, u/2)
+				for e := f.head[u]; e != -1; e = f.next[e] {
+					if e%2 == 0 && f.to[e] == u+1 {
+						used[e]++
+						break
+					}
+				}
+				u++
+			}
+		}
+		if u != f.sink {
+			break
+		}
+		paths = append(paths, append(path, f.sink/2))
+	}
+
+	sort.SliceStable(paths, func(i, j int) bool {
+		return f.graph.PathWeight(paths[i]) < f.graph.PathWeight(paths[j])
+	})
+	return paths
+}
+
+// FindOptimalPaths returns the maximum set of station-disjoint paths between
+// start and end, up to maxPaths. A station with capacity N may be on N paths.
+// Unlike FindMultiplePaths it can reroute paths found earlier, so a short
+// greedy path never blocks two others. Among sets of the same size, the one
+// with the lowest total weight is chosen.
+func FindOptimalPaths(graph *Graph, start, end string, maxPaths int) [][]string {
+	c := Compile(graph)
+	s, okStart := c.ID(start)
+	e, okEnd := c.ID(end)
+	if !okStart || !okEnd {
+		return nil
+	}
+	return c.pathsNames(c.FindOptimalPaths(s, e, maxPaths))
+}
+
+// FindOptimalPathsWithOptions is FindOptimalPaths keeping paths apart as
+// opts.Disjoint says; the other options do not apply. With NotDisjoint any
+// number of paths could share the shortest one, so instead every track
+// leaving start gets the shortest path through it, as FindMultiplePaths
+// finds them.
+func FindOptimalPathsWithOptions(graph *Graph, start, end string, maxPaths int, opts PathOptions) [][]string {
+	c := Compile(graph)
+	s, okStart := c.ID(start)
+	e, okEnd := c.ID(end)
+	if !okStart || !okEnd {
+		return nil
+	}
+	return c.pathsNames(c.FindOptimalPathsWithOptions(s, e, maxPaths, opts))
+}
+
+// FindOptimalPaths is FindOptimalPaths on station IDs.
+func (c *CompiledGraph) FindOptimalPaths(start, end, maxPaths int) [][]int {
+	return c.FindOptimalPathsWithOptions(start, end, maxPaths, PathOptions{})
+}
+
+// FindOptimalPathsWithOptions is FindOptimalPathsWithOptions on station IDs.
+func (c *CompiledGraph) FindOptimalPathsWithOptions(start, end, maxPaths int, opts PathOptions) [][]int {
+	if opts.Disjoint == NotDisjoint {
+		return c.FindMultiplePathsWithOptions(start, end, maxPaths, PathOptions{Disjoint: NotDisjoint})
+	}
+	f := newFlowNetwork(c, start, end, opts.Disjoint)
+	for k := 0; k < maxPaths; k++ {
+		if !f.augment() {
+			break
+		}
+	}
+	return f.paths()
+}
+
+// FindFastestPaths picks the station-disjoint path set that moves numTrains
+// in the fewest turns. For every k up to numTrains it takes the cheapest k
+// disjoint paths and tries each prefix of them, since one more long path
+// can slow the schedule down instead of helping. Returns the chosen paths
+// and the predicted turn count (see PredictTurns).
+func FindFastestPaths(graph *Graph, start, end string, numTrains int) ([][]string, int) {
+	c := Compile(graph)
+	s, okStart := c.ID(start)
+	e, okEnd := c.ID(end)
+	if !okStart || !okEnd {
+		return nil, 0
+	}
+	paths, turns := c.FindFastestPaths(s, e, numTrains)
+	return c.pathsNames(paths), turns
+}
+
+// FindFastestPathsWithOptions is FindFastestPaths keeping paths apart as
+// opts.Disjoint says, like FindOptimalPathsWithOptions. PredictTurns does not
+// hold once trains wait for each other at shared stations, so in the other
+// modes every candidate set is simulated and the turns returned are the
+// simulated ones.
+func FindFastestPathsWithOptions(graph *Graph, start, end string, numTrains int, opts PathOptions) ([][]string, int) {
+	c := Compile(graph)
+	s, okStart := c.ID(start)
+	e, okEnd := c.ID(end)
+	if !okStart || !okEnd {
+		return nil, 0
+	}
+	paths, turns := c.FindFastestPathsWithOptions(s, e, numTrains, opts)
+	return c.pathsNames(paths), turns
+}
+
+// FindFastestPaths is FindFastestPaths on station IDs.
+func (c *CompiledGraph) FindFastestPaths(start, end, numTrains int) ([][]int, int) {
+	return c.FindFastestPathsWithOptions(start, end, numTrains, PathOptions{})
+}
+
+// FindFastestPathsWithOptions is FindFastestPathsWithOptions on station IDs.
+func (c *CompiledGraph) FindFastestPathsWithOptions(start, end, numTrains int, opts PathOptions) ([][]int, int) {
+	var best [][]int
+	bestTurns := 0
+	turns := c.PredictTurns
+	if opts.Disjoint != StationDisjoint {
+		turns = c.simulatedTurns
+	}
+	// try keeps the fastest prefix of family; ties keep the smaller set.
+	// A single path always gets every train through, so some set is kept.
+	try := func(family [][]int) {
+		for n := 1; n <= len(family); n++ {
+			if t := turns(family[:n], numTrains); t != stuck && (best == nil || t < bestTurns) {
+				best = family[:n]
+				bestTurns = t
+			}
+		}
+	}
+
+	if opts.Disjoint == NotDisjoint {
+		try(c.FindOptimalPathsWithOptions(start, end, numTrains, opts))
+		return best, bestTurns
+	}
+	f := newFlowNetwork(c, start, end, opts.Disjoint)
+	for k := 0; k < numTrains; k++ {
+		if !f.augment() {
+			break
+		}
+		try(f.paths())
+	}
+	return best, bestTurns
+}
+
+// stuck is the turns of a path set some trains never get through.
+const stuck = math.MaxInt
+
+// simulatedTurns runs numTrains over paths, as AssignToPipelines spreads
+// them, and returns the turns the simulation takes, or stuck if not every
+// train arrives.
+func (c *CompiledGraph) simulatedTurns(paths [][]int, numTrains int) int {
+	g := c.pathGraph(paths)
+	schedule := g.Simulate(g.AssignToPipelines(c.pathsNames(paths), numTrains))
+	if schedule.Arrived < numTrains {
+		return stuck
+	}
+	return schedule.TotalTurns
+}
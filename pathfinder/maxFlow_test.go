@@ -0,0 +1,91 @@
+package pathfinder
+
+import (
+	"strings"
+	"testing"
+)
+
+// The greedy search takes a first, whose shortest path through m leaves b
+// with no way to t; max flow sends a the long way round instead.
+const greedyTrapMap = `stations:
+s,0,2
+a,1,1
+b,1,3
+m,2,2
+p,2,0
+q,3,0
+t,4,2
+z1,0,4
+z2,1,5
+
+connections:
+s-a
+s-b
+a-m
+m-t
+a-p
+p-q
+q-t
+b-m
+b-z1
+b-z2
+`
+
+func TestFindOptimalPaths(t *testing.T) {
+	tests := []struct {
+		name       string
+		mapText    string
+		start, end string
+		greedy     int
+		optimal    int
+	}{
+		{"greedy trap", greedyTrapMap, "s", "t", 1, 2},
+		{"bow tie", bowTieMap, "s", "t", 1, 1},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			g, err := ParseMap(strings.NewReader(tc.mapText))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := FindMultiplePaths(g, tc.start, tc.end, 10); len(got) != tc.greedy {
+				t.Errorf("greedy found %d paths, want %d: %v", len(got), tc.greedy, got)
+			}
+			paths := FindOptimalPaths(g, tc.start, tc.end, 10)
+			if len(paths) != tc.optimal {
+				t.Fatalf("optimal found %d paths, want %d: %v", len(paths), tc.optimal, paths)
+			}
+			seen := make(map[string]bool)
+			for _, path := range paths {
+				if path[0] != tc.start || path[len(path)-1] != tc.end {
+					t.Errorf("path %v does not run from %s to %s", path, tc.start, tc.end)
+				}
+				for _, s := range path[1 : len(path)-1] {
+					if seen[s] {
+						t.Errorf("station %s is on two paths: %v", s, paths)
+					}
+					seen[s] = true
+				}
+			}
+			if got := FindOptimalPaths(g, tc.start, tc.end, 1); len(got) != 1 {
+				t.Errorf("maxPaths 1 gave %d paths", len(got))
+			}
+		})
+	}
+}
+
+func TestFindFastestPathsGetsEveryTrainThrough(t *testing.T) {
+	g, err := ParseMap(strings.NewReader(crossingWeightedMap))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range []Disjointness{EdgeDisjoint, NotDisjoint} {
+		for trains := 1; trains <= 8; trains++ {
+			paths, turns := FindFastestPathsWithOptions(g, "s0", "s1", trains, PathOptions{Disjoint: d})
+			schedule := g.Simulate(g.AssignToPipelines(paths, trains))
+			if schedule.Arrived != trains || schedule.TotalTurns != turns {
+				t.Errorf("%s, %d trains: %d arrived in %d turns, %d returned", d, trains, schedule.Arrived, schedule.TotalTurns, turns)
+			}
+		}
+	}
+}
@@ -1,9 +1,9 @@
 package pathfinder
 
 import (
-	"bufio"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"regexp"
 	"strconv"
@@ -13,112 +13,483 @@ import (
 
 // ---- Data Structures ----
 type Station struct {
-	Name string
-	X, Y int
+	Name     string
+	X, Y     int
+	Capacity int // trains the station can hold at once, 1 unless set in the map
 }
 
+// Graph is a parsed map. Nothing in this package changes a Graph it is
+// given, so goroutines may share one as long as none of them changes it;
+// SyncGraph also allows changes.
 type Graph struct {
 	Stations    map[string]*Station
 	Connections map[string][]string
+	Weights     map[string]int     // turns to cross a connection, keyed by trackKey; missing means 1
+	OneWay      map[string]bool    // one-way connections ("a>b"), keyed by directedEdgeKey
+	Comments    map[string]Comment // see Comment, only used by WriteMap
+
+	// File order of stations and connections (connectionKey), for WriteOptions.KeepOrder
+	stationOrder    []string
+	connectionOrder []string
+}
+
+// Comment holds the comments of one element of a map file: whole comment
+// lines just above it and the comment at the end of its own line. Keys in
+// Graph.Comments are the section header ("stations:", "connections:"), the
+// station name, or connectionKey for a connection; "" holds the comments
+// after the last element.
+type Comment struct {
+	Above  []string
+	Inline string
+}
+
+// Weight returns how many turns a train needs to travel from a to b.
+func (g *Graph) Weight(a, b string) int {
+	if g == nil {
+		return 1
+	}
+	if w, ok := g.Weights[g.trackKey(a, b)]; ok {
+		return w
+	}
+	return 1
+}
+
+// IsOneWay reports whether the connection from a to b is a one-way track.
+// A pair of one-way tracks "a>b" and "b>a" are two tracks, unlike "a-b".
+func (g *Graph) IsOneWay(a, b string) bool {
+	return g != nil && g.OneWay[directedEdgeKey(a, b)]
+}
+
+// trackKey names the track a train uses from a to b: directedEdgeKey for a
+// one-way track, normalizeEdgeKey for a track shared by both directions.
+func (g *Graph) trackKey(a, b string) string {
+	if g.IsOneWay(a, b) {
+		return directedEdgeKey(a, b)
+	}
+	return normalizeEdgeKey(a, b)
+}
+
+// Capacity returns how many trains the station can hold at once.
+func (g *Graph) Capacity(name string) int {
+	if g == nil {
+		return 1
+	}
+	if s, ok := g.Stations[name]; ok && s.Capacity > 0 {
+		return s.Capacity
+	}
+	return 1
+}
+
+// PathWeight returns the total number of turns needed to travel the path.
+func (g *Graph) PathWeight(path []string) int {
+	total := 0
+	for i := 1; i < len(path); i++ {
+		total += g.Weight(path[i-1], path[i])
+	}
+	return total
+}
+
+// ---- Errors ----
+
+// Kinds of map file problems. Every MapError wraps one of these, so callers
+// can use errors.Is instead of matching messages.
+var (
+	ErrCannotOpen           = errors.New("cannot open map file")
+	ErrMissingSection       = errors.New("missing section")
+	ErrTooManyStations      = errors.New("too many stations")
+	ErrInvalidStation       = errors.New("invalid station format")
+	ErrInvalidName          = errors.New("invalid station name")
+	ErrInvalidCoordinates   = errors.New("invalid coordinates")
+	ErrInvalidCapacity      = errors.New("invalid capacity")
+	ErrDuplicateStation     = errors.New("duplicate station")
+	ErrDuplicateCoordinates = errors.New("duplicate coordinates")
+	ErrInvalidConnection    = errors.New("invalid connection format")
+	ErrUnknownStation       = errors.New("unknown station")
+	ErrDuplicateConnection  = errors.New("duplicate connection")
+	ErrInvalidWeight        = errors.New("invalid connection weight")
+	ErrTooManyConnections   = errors.New("too many connections")
+	ErrLineTooLong          = errors.New("line too long")
+	ErrFileTooLarge         = errors.New("map file too large")
+)
+
+// MapError is one problem found in a map file. Line and Column start at 1
+// and are 0 when the problem has no position, such as a missing section.
+// Token is the offending part of the line.
+type MapError struct {
+	File   string
+	Line   int
+	Column int
+	Token  string
+	Err    error // one of the Err* kinds above
+	Msg    string
+}
+
+func (e *MapError) Error() string {
+	switch {
+	case e.File == "" && e.Line == 0:
+		return e.Msg
+	case e.File == "":
+		return fmt.Sprintf("%d:%d: %s", e.Line, e.Column, e.Msg)
+	case e.Line == 0:
+		return fmt.Sprintf("%s: %s", e.File, e.Msg)
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", e.File, e.Line, e.Column, e.Msg)
+}
+
+func (e *MapError) Unwrap() error {
+	return e.Err
+}
+
+// MapErrors is every problem ValidateMapFile found, in file order.
+// errors.Is and errors.As look through all of them.
+type MapErrors []*MapError
+
+func (errs MapErrors) Error() string {
+	lines := make([]string, len(errs))
+	for i, e := range errs {
+		lines[i] = e.Error()
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (errs MapErrors) Unwrap() []error {
+	wrapped := make([]error, len(errs))
+	for i, e := range errs {
+		wrapped[i] = e
+	}
+	return wrapped
 }
 
 // ---- Parsing ----
+
+// NoLimit turns off one of the ParseOptions limits.
+const NoLimit = -1
+
+// DefaultMaxStations is the station limit used when ParseOptions leaves it unset.
+const DefaultMaxStations = 10000
+
+// ParseOptions sets the limits the parser enforces. A zero field uses the
+// default: DefaultMaxStations for MaxStations, no limit for the others.
+// NoLimit turns a limit off.
+type ParseOptions struct {
+	MaxStations    int
+	MaxConnections int
+	MaxLineLength  int   // bytes per line, comments included
+	MaxFileSize    int64 // bytes
+}
+
+// limit returns the effective value of a limit field, 0 meaning no limit.
+func limit[T int | int64](value, fallback T) T {
+	switch {
+	case value == 0:
+		return fallback
+	case value < 0:
+		return 0
+	}
+	return value
+}
+
+func (o ParseOptions) maxStations() int { return limit(o.MaxStations, DefaultMaxStations) }
+
+// ParseMapFile parses a map file and stops at the first problem.
 func ParseMapFile(path string) (*Graph, error) {
+	return ParseMapFileWithOptions(path, ParseOptions{})
+}
+
+// ParseMapFileWithOptions is ParseMapFile with parser limits.
+func ParseMapFileWithOptions(path string, opts ParseOptions) (*Graph, error) {
+	g, errs := parseMapFile(path, 1, opts)
+	if len(errs) > 0 {
+		return nil, errs[0]
+	}
+	return g, nil
+}
 
+// ValidateMapFile parses a map file and collects every problem instead of
+// stopping at the first one, up to maxErrors (0 means no limit). The graph
+// is only returned when there are no errors.
+func ValidateMapFile(path string, maxErrors int) (*Graph, MapErrors) {
+	return ValidateMapFileWithOptions(path, maxErrors, ParseOptions{})
+}
+
+// ValidateMapFileWithOptions is ValidateMapFile with parser limits.
+func ValidateMapFileWithOptions(path string, maxErrors int, opts ParseOptions) (*Graph, MapErrors) {
+	g, errs := parseMapFile(path, maxErrors, opts)
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return g, nil
+}
+
+var (
+	commentRe     = regexp.MustCompile(`#.*`)
+	spacesRe      = regexp.MustCompile(` +`)
+	stationNameRe = regexp.MustCompile(`^[a-z0-9_]+$`)
+)
+
+// ParseMap parses a map from r and stops at the first problem. Errors
+// carry no file name.
+func ParseMap(r io.Reader) (*Graph, error) {
+	return ParseMapWithOptions(r, ParseOptions{})
+}
+
+// ParseMapWithOptions is ParseMap with parser limits. With MaxFileSize set,
+// no more than that is read from r.
+func ParseMapWithOptions(r io.Reader, opts ParseOptions) (*Graph, error) {
+	if maxSize := limit(opts.MaxFileSize, 0); maxSize > 0 {
+		r = io.LimitReader(r, maxSize+1)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, &MapError{Err: ErrCannotOpen, Msg: fmt.Sprintf("cannot read map: %s", err)}
+	}
+	if err := checkFileSize("", int64(len(data)), opts); err != nil {
+		return nil, err
+	}
+	g, errs := parseMap("", data, 1, opts)
+	if len(errs) > 0 {
+		return nil, errs[0]
+	}
+	return g, nil
+}
+
+func parseMapFile(path string, maxErrors int, opts ParseOptions) (*Graph, MapErrors) {
+	// Check the size before reading a file that is too large
+	if info, err := os.Stat(path); err == nil {
+		if err := checkFileSize(path, info.Size(), opts); err != nil {
+			return nil, MapErrors{err}
+		}
+	}
 	file, err := os.ReadFile(path)
 	if err != nil {
-		return nil, errors.New("cannot open map file")
+		return nil, MapErrors{{File: path, Err: ErrCannotOpen, Msg: fmt.Sprintf("cannot open map file: %s", errors.Unwrap(err))}}
+	}
+	if err := checkFileSize(path, int64(len(file)), opts); err != nil {
+		return nil, MapErrors{err}
+	}
+	return parseMap(path, file, maxErrors, opts)
+}
+
+func checkFileSize(path string, size int64, opts ParseOptions) *MapError {
+	if maxSize := limit(opts.MaxFileSize, 0); maxSize > 0 && size > maxSize {
+		return &MapError{File: path, Err: ErrFileTooLarge, Msg: fmt.Sprintf("the map file is larger than %d bytes", maxSize)}
+	}
+	return nil
+}
+
+// parseMap does the parsing for every entry point; path is only used in errors.
+func parseMap(path string, file []byte, maxErrors int, opts ParseOptions) (*Graph, MapErrors) {
+	var errs MapErrors
+	full := func() bool {
+		return maxErrors > 0 && len(errs) >= maxErrors
+	}
+	// fail records a problem; token is looked up in the raw line for the
+	// column. A leading ",", "-" or ">" in token anchors the search to a field.
+	// An empty token stands for the whole line.
+	fail := func(kind error, lineNum int, raw, token string, format string, args ...any) {
+		column := 1
+		if i := strings.Index(raw, token); i >= 0 && token != "" {
+			column = i + 1
+			if token[0] == ',' || token[0] == '-' || token[0] == '>' {
+				column++
+				token = token[1:]
+			}
+		}
+		if token == "" {
+			token = strings.TrimSpace(raw)
+		}
+		errs = append(errs, &MapError{path, lineNum, column, token, kind, fmt.Sprintf(format, args...)})
 	}
 
-	// Remove comments and spaces
-	text := regexp.MustCompile(`#.*`).ReplaceAllString(string(file), "")
-	text = regexp.MustCompile(` +`).ReplaceAllString(text, "")
+	// Remove comments and spaces, line by line so positions stay known
+	raw := strings.Split(string(file), "\n")
+	lines := make([]string, len(raw))
+	maxLine := limit(opts.MaxLineLength, 0)
+	for i, line := range raw {
+		// Windows line endings
+		line = strings.TrimSuffix(line, "\r")
+		raw[i] = line
+		if maxLine > 0 && len(line) > maxLine {
+			fail(ErrLineTooLong, i+1, line[:maxLine], "", "line is longer than %d bytes", maxLine)
+			if full() {
+				return nil, errs
+			}
+			line, raw[i] = "", ""
+		}
+		lines[i] = spacesRe.ReplaceAllString(commentRe.ReplaceAllString(line, ""), "")
+	}
 
 	// Check "stations:" and "connections:"
-	if !strings.Contains(text, "stations:") {
-		return nil, fmt.Errorf("missing stations section in %q", path)
+	if !slices.Contains(lines, "stations:") {
+		errs = append(errs, &MapError{File: path, Token: "stations:", Err: ErrMissingSection, Msg: "missing stations section"})
+		if full() {
+			return nil, errs
+		}
 	}
-	if !strings.Contains(text, "connections:") {
-		return nil, fmt.Errorf("missing connections section in %q", path)
+	if !slices.Contains(lines, "connections:") {
+		errs = append(errs, &MapError{File: path, Token: "connections:", Err: ErrMissingSection, Msg: "missing connections section"})
+	}
+	// Without stations every connection would be reported as unknown
+	if full() || !slices.Contains(lines, "stations:") {
+		return nil, errs
 	}
 
 	g := &Graph{
 		Stations:    make(map[string]*Station),
 		Connections: make(map[string][]string),
+		Weights:     make(map[string]int),
+		OneWay:      make(map[string]bool),
+		Comments:    make(map[string]Comment),
 	}
-	scanner := bufio.NewScanner(strings.NewReader(text))
 
 	coords := make(map[[2]int]string)
-	stationCount := 0
-	countStrings := 0
+	stationCount, connectionCount := 0, 0
+	maxStations, maxConnections := opts.maxStations(), limit(opts.MaxConnections, 0)
 	section := ""
-	re := regexp.MustCompile(`^[a-z0-9_]+$`)
 
-	for scanner.Scan() {
-		countStrings++
-		line := scanner.Text()
+	// Whole-line comments wait here for the next element
+	var pending []string
+	keep := func(key, raw string) {
+		if c := (Comment{pending, commentRe.FindString(raw)}); len(c.Above) > 0 || c.Inline != "" {
+			g.Comments[key] = c
+		}
+		pending = nil
+	}
+
+	for i, line := range lines {
+		if full() {
+			break
+		}
+		countStrings := i + 1
 		if line == "" {
+			if c := commentRe.FindString(raw[i]); c != "" {
+				pending = append(pending, c)
+			}
 			continue
 		}
 		switch line {
 		case "stations:":
 			section = "stations"
+			keep(line, raw[i])
 			continue
 		case "connections:":
 			section = "connections"
+			keep(line, raw[i])
 			continue
 		}
 
 		switch section {
 		case "stations":
 			stationCount++
-			if stationCount > 10000 {
-				return nil, errors.New("the map file contains more than 10000 stations")
+			if maxStations > 0 && stationCount > maxStations {
+				fail(ErrTooManyStations, countStrings, raw[i], "", "the map file contains more than %d stations", maxStations)
+				return nil, errs
 			}
 			parts := strings.Split(line, ",")
-			if len(parts) != 3 {
-				return nil, fmt.Errorf("invalid station format: %q\nString number in the map file: %d", line, countStrings)
+			// Optional capacity: name,x,y,capacity
+			if len(parts) != 3 && len(parts) != 4 {
+				fail(ErrInvalidStation, countStrings, raw[i], "", "invalid station format: %q", line)
+				continue
 			}
 
 			name := parts[0]
-			if !re.MatchString(name) {
-				return nil, fmt.Errorf("invalid station name: %q\nString number in the map file: %d", name, countStrings)
+			if !stationNameRe.MatchString(name) {
+				fail(ErrInvalidName, countStrings, raw[i], name, "invalid station name: %q", name)
+				continue
 			}
 
 			x, err1 := strconv.Atoi(parts[1])
 			y, err2 := strconv.Atoi(parts[2])
 			if err1 != nil || err2 != nil || x < 0 || y < 0 {
-				return nil, fmt.Errorf("invalid coordinates %q. Station coordinates must be positive integers\nString number in the map file: %d", line, countStrings)
+				bad := "," + parts[1]
+				if err1 == nil && x >= 0 {
+					bad = "," + parts[2]
+				}
+				fail(ErrInvalidCoordinates, countStrings, raw[i], bad, "invalid coordinates %q. Station coordinates must be positive integers", line)
+				continue
+			}
+			capacity := 1
+			if len(parts) == 4 {
+				var err error
+				capacity, err = strconv.Atoi(parts[3])
+				if err != nil || capacity < 1 {
+					fail(ErrInvalidCapacity, countStrings, raw[i], parts[3], "invalid capacity %q. Station capacity must be a positive integer", parts[3])
+					continue
+				}
 			}
 			if _, exists := g.Stations[name]; exists {
-				return nil, fmt.Errorf("duplicate station %q\nString number in the map file: %d", name, countStrings)
+				fail(ErrDuplicateStation, countStrings, raw[i], name, "duplicate station %q", name)
+				continue
 			}
 			coord := [2]int{x, y}
 			if _, dup := coords[coord]; dup {
-				return nil, fmt.Errorf("the stations %q and \"%s,%d,%d\" have the same coordinates\nString number in the map file: %d", line, coords[coord], x, y, countStrings)
+				fail(ErrDuplicateCoordinates, countStrings, raw[i], ","+parts[1], "the stations %q and \"%s,%d,%d\" have the same coordinates", line, coords[coord], x, y)
+				continue
 			}
 			coords[coord] = name
-			g.Stations[name] = &Station{name, x, y}
+			g.Stations[name] = &Station{name, x, y, capacity}
+			keep(name, raw[i])
+			g.stationOrder = append(g.stationOrder, name)
 
 		case "connections":
-			parts := strings.Split(line, "-")
-			if len(parts) != 2 {
-				return nil, fmt.Errorf("invalid connection format: %q\nString number in the map file: %d", line, countStrings)
+			connectionCount++
+			if maxConnections > 0 && connectionCount > maxConnections {
+				fail(ErrTooManyConnections, countStrings, raw[i], "", "the map file contains more than %d connections", maxConnections)
+				return nil, errs
+			}
+			// Optional travel time: a-b,3. One-way tracks are written a>b.
+			edge, weightText, weighted := strings.Cut(line, ",")
+			separator := "-"
+			if strings.Contains(edge, ">") {
+				separator = ">"
+			}
+			parts := strings.Split(edge, separator)
+			if len(parts) != 2 || strings.Contains(edge, "-") && separator == ">" {
+				fail(ErrInvalidConnection, countStrings, raw[i], "", "invalid connection format: %q", line)
+				continue
 			}
 			u, v := parts[0], parts[1]
+			oneWay := separator == ">"
 			if _, ok := g.Stations[u]; !ok {
-				return nil, fmt.Errorf("unknown station %q in connection %q\nString number in the map file: %d", u, line, countStrings)
+				fail(ErrUnknownStation, countStrings, raw[i], u, "unknown station %q in connection %q", u, line)
+				continue
 			}
 			if _, ok := g.Stations[v]; !ok {
-				return nil, fmt.Errorf("unknown station %q in connection %q\nString number in the map file: %d", v, line, countStrings)
+				fail(ErrUnknownStation, countStrings, raw[i], separator+v, "unknown station %q in connection %q", v, line)
+				continue
 			}
-			// prevent duplicates
-			if slices.Contains(g.Connections[u], v) {
-				return nil, fmt.Errorf("duplicate connection between %q and %q\nString number in the map file: %d", u, v, countStrings)
+			// prevent duplicates; "a>b" and "b>a" are two separate tracks
+			if slices.Contains(g.Connections[u], v) || !oneWay && slices.Contains(g.Connections[v], u) {
+				fail(ErrDuplicateConnection, countStrings, raw[i], u, "duplicate connection between %q and %q", u, v)
+				continue
+			}
+			key := connectionKey(u, v)
+			if oneWay {
+				key = directedEdgeKey(u, v)
+				g.OneWay[key] = true
+			}
+			if weighted {
+				w, err := strconv.Atoi(weightText)
+				if err != nil || w < 1 {
+					fail(ErrInvalidWeight, countStrings, raw[i], ","+weightText, "invalid connection weight %q. Connection weights must be positive integers", weightText)
+					delete(g.OneWay, key)
+					continue
+				}
+				g.Weights[g.trackKey(u, v)] = w
 			}
 			g.Connections[u] = append(g.Connections[u], v)
-			g.Connections[v] = append(g.Connections[v], u)
+			if !oneWay {
+				g.Connections[v] = append(g.Connections[v], u)
+			}
+			keep(key, raw[i])
+			g.connectionOrder = append(g.connectionOrder, key)
 		}
 	}
+	if len(pending) > 0 {
+		g.Comments[""] = Comment{Above: pending}
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
 	return g, nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,205 @@
+package pathfinder
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseMapFileErrorKinds(t *testing.T) {
+	tests := []struct {
+		mapFile string
+		kind    error
+		line    int
+		token   string
+	}{
+		{"missing.map", ErrCannotOpen, 0, ""},
+		{"LondonWithoutStations.map", ErrMissingSection, 0, "stations:"},
+		{"LondonWithoutConnections.map", ErrMissingSection, 0, "connections:"},
+		{"LondonNameDuplicated.map", ErrDuplicateStation, 6, "euston"},
+		{"LondonSameCoordinates.map", ErrDuplicateCoordinates, 4, "6"},
+		{"LondonNotPositiveIntengerCoordinate.map", ErrInvalidCoordinates, 3, "-7"},
+		{"LondonConnectionWithNotExistStation.map", ErrUnknownStation, 13, "water"},
+		{"LondonDuplicateRoutes.map", ErrDuplicateConnection, 12, "euston"},
+		{"10001.map", ErrTooManyStations, 10002, ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.mapFile, func(t *testing.T) {
+			_, err := ParseMapFile("../testdata/" + tc.mapFile)
+			if !errors.Is(err, tc.kind) {
+				t.Fatalf("got %v, want %v", err, tc.kind)
+			}
+			var mapErr *MapError
+			if !errors.As(err, &mapErr) {
+				t.Fatalf("%v is not a *MapError", err)
+			}
+			if mapErr.Line != tc.line {
+				t.Errorf("line %d, want %d", mapErr.Line, tc.line)
+			}
+			if tc.token != "" && mapErr.Token != tc.token {
+				t.Errorf("token %q, want %q", mapErr.Token, tc.token)
+			}
+		})
+	}
+}
+
+func TestValidateMapFileCollectsEveryError(t *testing.T) {
+	_, errs := ValidateMapFile("../testdata/LondonTypedErrors.map", 0)
+	want := []error{ErrInvalidName, ErrDuplicateStation, ErrDuplicateCoordinates, ErrUnknownStation, ErrDuplicateConnection}
+	if len(errs) != len(want) {
+		t.Fatalf("got %d errors, want %d:\n%v", len(errs), len(want), errs)
+	}
+	for i, kind := range want {
+		if !errors.Is(errs[i], kind) {
+			t.Errorf("error %d: got %v, want %v", i, errs[i], kind)
+		}
+	}
+	if !errors.Is(errs, ErrUnknownStation) {
+		t.Error("errors.Is does not look through MapErrors")
+	}
+
+	if _, errs := ValidateMapFile("../testdata/LondonTypedErrors.map", 2); len(errs) != 2 {
+		t.Errorf("maxErrors 2 returned %d errors", len(errs))
+	}
+
+	// Maps read from an io.Reader have no file, and missing sections no line
+	_, err := ParseMap(strings.NewReader("connections:\n"))
+	if err == nil || err.Error() != "missing stations section" {
+		t.Errorf("got %q, want the message alone", err)
+	}
+}
+
+func TestCRLFLineEndings(t *testing.T) {
+	crlf, err := ParseMapFile("../testdata/LondonCRLF.map")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lf, err := ParseMapFile("../testdata/London.map")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want, got bytes.Buffer
+	if err := WriteMap(&want, lf); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteMap(&got, crlf); err != nil {
+		t.Fatal(err)
+	}
+	if got.String() != want.String() {
+		t.Errorf("CRLF map differs:\n%s\nwant:\n%s", got.String(), want.String())
+	}
+}
+
+func TestWriteMapRoundTrip(t *testing.T) {
+	for _, mapFile := range []string{"London.map", "small.map", "weighted.map", "interchange.map", "oneway.map", "10000.map"} {
+		t.Run(mapFile, func(t *testing.T) {
+			g, err := ParseMapFile("../testdata/" + mapFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var first bytes.Buffer
+			if err := WriteMap(&first, g); err != nil {
+				t.Fatal(err)
+			}
+			text := first.String()
+
+			again, err := ParseMap(&first)
+			if err != nil {
+				t.Fatalf("%v\n%s", err, text)
+			}
+			if !reflect.DeepEqual(again.Stations, g.Stations) {
+				t.Error("stations differ after round trip")
+			}
+			if !reflect.DeepEqual(again.Weights, g.Weights) {
+				t.Error("weights differ after round trip")
+			}
+			if !reflect.DeepEqual(again.OneWay, g.OneWay) {
+				t.Error("one-way connections differ after round trip")
+			}
+			if !reflect.DeepEqual(again.Comments, g.Comments) {
+				t.Errorf("comments differ after round trip:\n%v\n%v", g.Comments, again.Comments)
+			}
+			for name, nbrs := range g.Connections {
+				if !reflect.DeepEqual(slices.Sorted(slices.Values(again.Connections[name])), slices.Sorted(slices.Values(nbrs))) {
+					t.Errorf("connections of %q differ after round trip", name)
+				}
+			}
+
+			var second bytes.Buffer
+			if err := WriteMap(&second, again); err != nil {
+				t.Fatal(err)
+			}
+			if second.String() != text {
+				t.Error("writing the same graph twice gives different text")
+			}
+		})
+	}
+}
+
+func TestOneWayConnections(t *testing.T) {
+	g, err := ParseMapFile("../testdata/oneway.map")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(g.Connections["north"], "harbour") || slices.Contains(g.Connections["harbour"], "north") {
+		t.Errorf("north>harbour: got %v and %v", g.Connections["north"], g.Connections["harbour"])
+	}
+	if g.Weight("depot", "yard") != 2 || g.Weight("yard", "depot") != 1 {
+		t.Error("one-way weight is not kept per direction")
+	}
+	for _, path := range FindMultiplePaths(g, "depot", "harbour", 10) {
+		if slices.Contains(path, "south") {
+			t.Errorf("path %v uses harbour>south backwards", path)
+		}
+	}
+
+	for _, tc := range []struct {
+		connections string
+		kind        error
+	}{
+		{"a-b\na>b", ErrDuplicateConnection},
+		{"a>b\nb-a", ErrDuplicateConnection},
+		{"a>b\na>b", ErrDuplicateConnection},
+		{"a>b-c", ErrInvalidConnection},
+		{"a>x", ErrUnknownStation},
+	} {
+		_, err := ParseMap(strings.NewReader("stations:\na,0,0\nb,1,0\nc,2,0\n\nconnections:\n" + tc.connections + "\n"))
+		if !errors.Is(err, tc.kind) {
+			t.Errorf("%q: got %v, want %v", tc.connections, err, tc.kind)
+		}
+	}
+}
+
+func TestParseOptionsLimits(t *testing.T) {
+	if _, err := ParseMapFileWithOptions("../testdata/10001.map", ParseOptions{MaxStations: NoLimit}); err != nil {
+		t.Errorf("NoLimit: %v", err)
+	}
+	tests := []struct {
+		opts ParseOptions
+		kind error
+	}{
+		{ParseOptions{MaxStations: 2}, ErrTooManyStations},
+		{ParseOptions{MaxConnections: 3}, ErrTooManyConnections},
+		{ParseOptions{MaxLineLength: 10}, ErrLineTooLong},
+		{ParseOptions{MaxFileSize: 100}, ErrFileTooLarge},
+	}
+	for _, tc := range tests {
+		t.Run(tc.kind.Error(), func(t *testing.T) {
+			if _, err := ParseMapFileWithOptions("../testdata/London.map", tc.opts); !errors.Is(err, tc.kind) {
+				t.Errorf("file: got %v, want %v", err, tc.kind)
+			}
+			file, err := os.Open("../testdata/London.map")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+			if _, err := ParseMapWithOptions(file, tc.opts); !errors.Is(err, tc.kind) {
+				t.Errorf("reader: got %v, want %v", err, tc.kind)
+			}
+		})
+	}
+}
@@ -9,16 +9,72 @@ type Train struct {
 	Path   []string
 	Index  int
 	Active bool
+	Travel int // turns left on the current connection after this one, 0 when at a station
 }
 
 // Train Assignment
+// Every connection counts as one turn; use Graph.AssignToPipelines for
+// weighted maps.
 func AssignToPipelines(paths [][]string, numTrains int) []*Train {
-	numPipelines := len(paths)
+	var graph *Graph
+	return graph.AssignToPipelines(paths, numTrains)
+}
+
+// AssignToPipelines is AssignToPipelines with the weights of g. A nil graph
+// treats every connection as one turn long.
+func (g *Graph) AssignToPipelines(paths [][]string, numTrains int) []*Train {
+	lengths, headways := g.pipelineTimes(paths)
+	trainAssignments := assignTrains(lengths, headways, numTrains)
+
+	trains := make([]*Train, numTrains)
+	for i, pipelineIndex := range trainAssignments {
+		trains[i] = &Train{
+			Name:   fmt.Sprintf("T%d", i+1),
+			Path:   paths[pipelineIndex],
+			Index:  0,
+			Active: true,
+		}
+	}
+	return trains
+}
+
+// pipelineTimes is pipelineTimes on station names. Only the stations the
+// paths use are compiled, so a nil graph counts one turn per connection.
+func (g *Graph) pipelineTimes(paths [][]string) (lengths, headways []int) {
+	c := Compile(pathGraph(g, paths))
+	ids := make([][]int, len(paths))
+	for i, path := range paths {
+		ids[i] = make([]int, len(path))
+		for j, name := range path {
+			ids[i][j], _ = c.ID(name)
+		}
+	}
+	return c.pipelineTimes(ids)
+}
 
-	pipelineLengths := make([]int, numPipelines)
+// pipelineTimes returns how many turns each path takes and its headway:
+// how many turns apart trains can follow each other along it. A connection
+// stays blocked until the train on it arrives, so the slowest connection
+// sets the pace.
+func (c *CompiledGraph) pipelineTimes(paths [][]int) (lengths, headways []int) {
+	lengths = make([]int, len(paths))
+	headways = make([]int, len(paths))
 	for i, path := range paths {
-		pipelineLengths[i] = len(path) - 1
+		headways[i] = 1
+		for j := 1; j < len(path); j++ {
+			w := c.Weight(path[j-1], path[j])
+			lengths[i] += w
+			headways[i] = max(headways[i], w)
+		}
 	}
+	return lengths, headways
+}
+
+// assignTrains returns the pipeline index for each train. Every pipeline
+// gets one train first, the rest go where the next train would arrive
+// soonest: path length plus one headway per train already queued.
+func assignTrains(pipelineLengths, headways []int, numTrains int) []int {
+	numPipelines := len(pipelineLengths)
 
 	trainsPerPipeline := make([]int, numPipelines)
 	trainAssignments := make([]int, numTrains)
@@ -30,10 +86,10 @@ func AssignToPipelines(paths [][]string, numTrains int) []*Train {
 
 	for i := numPipelines; i < numTrains; i++ {
 		bestPipeline := 0
-		bestScore := trainsPerPipeline[0] + pipelineLengths[0]
+		bestScore := trainsPerPipeline[0]*headways[0] + pipelineLengths[0]
 
 		for j := 1; j < numPipelines; j++ {
-			score := trainsPerPipeline[j] + pipelineLengths[j]
+			score := trainsPerPipeline[j]*headways[j] + pipelineLengths[j]
 
 			if score < bestScore || (score == bestScore && pipelineLengths[j] < pipelineLengths[bestPipeline]) {
 				bestPipeline = j
@@ -43,15 +99,44 @@ func AssignToPipelines(paths [][]string, numTrains int) []*Train {
 		trainAssignments[i] = bestPipeline
 		trainsPerPipeline[bestPipeline]++
 	}
+	return trainAssignments
+}
 
-	trains := make([]*Train, numTrains)
-	for i, pipelineIndex := range trainAssignments {
-		trains[i] = &Train{
-			Name:   fmt.Sprintf("T%d", i+1),
-			Path:   paths[pipelineIndex],
-			Index:  0,
-			Active: true,
+// PredictTurns returns how many turns the simulation needs to move
+// numTrains along station-disjoint paths distributed by AssignToPipelines.
+// A path taking L turns and carrying n trains finishes on turn
+// L+(n-1)*headway; with one-turn connections that is L+n-1.
+func PredictTurns(paths [][]string, numTrains int) int {
+	var graph *Graph
+	return graph.PredictTurns(paths, numTrains)
+}
+
+// PredictTurns is PredictTurns with the weights of g.
+func (g *Graph) PredictTurns(paths [][]string, numTrains int) int {
+	lengths, headways := g.pipelineTimes(paths)
+	return predictTurns(lengths, headways, numTrains)
+}
+
+// PredictTurns is PredictTurns on station IDs.
+func (c *CompiledGraph) PredictTurns(paths [][]int, numTrains int) int {
+	lengths, headways := c.pipelineTimes(paths)
+	return predictTurns(lengths, headways, numTrains)
+}
+
+func predictTurns(lengths, headways []int, numTrains int) int {
+	if len(lengths) == 0 || numTrains <= 0 {
+		return 0
+	}
+	trainsPerPipeline := make([]int, len(lengths))
+	for _, pipelineIndex := range assignTrains(lengths, headways, numTrains) {
+		trainsPerPipeline[pipelineIndex]++
+	}
+	turns := 0
+	for i, n := range trainsPerPipeline {
+		if n == 0 {
+			continue
 		}
+		turns = max(turns, lengths[i]+(n-1)*headways[i])
 	}
-	return trains
-}
\ No newline at end of file
+	return turns
+}
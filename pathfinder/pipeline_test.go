@@ -0,0 +1,107 @@
+package pathfinder
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPredictedTurnsMatchSimulation(t *testing.T) {
+	for _, tc := range scheduleCases {
+		t.Run(tc.mapFile, func(t *testing.T) {
+			g, err := ParseMapFile("../testdata/" + tc.mapFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for trains := 1; trains <= tc.trains; trains++ {
+				paths, turns := FindFastestPaths(g, tc.start, tc.end, trains)
+				if got := g.PredictTurns(paths, trains); got != turns {
+					t.Errorf("%d trains: FindFastestPaths says %d turns, PredictTurns %d", trains, turns, got)
+				}
+				if got := g.Simulate(g.AssignToPipelines(paths, trains)).TotalTurns; got != turns {
+					t.Errorf("%d trains: predicted %d turns, simulated %d", trains, turns, got)
+				}
+			}
+		})
+	}
+}
+
+// A short line and a long one: the long line only pays off once enough
+// trains queue on the short one.
+const shortAndLongMap = `stations:
+s,0,0
+a,1,0
+b,0,1
+c,0,2
+d,1,3
+e,2,3
+f,3,2
+t,2,0
+
+connections:
+s-a
+a-t
+s-b
+b-c
+c-d
+d-e
+e-f
+f-t
+`
+
+func TestFindFastestPathsRejectsLongPaths(t *testing.T) {
+	g, err := ParseMap(strings.NewReader(shortAndLongMap))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		trains, paths, turns int
+	}{
+		{1, 1, 2},
+		{2, 1, 3},
+		{5, 1, 6},
+		{6, 2, 6},
+		{10, 2, 8},
+	}
+	for _, tc := range tests {
+		paths, turns := FindFastestPaths(g, "s", "t", tc.trains)
+		if len(paths) != tc.paths || turns != tc.turns {
+			t.Errorf("%d trains: %d paths in %d turns, want %d in %d", tc.trains, len(paths), turns, tc.paths, tc.turns)
+		}
+		if got := g.Simulate(g.AssignToPipelines(paths, tc.trains)).TotalTurns; got != turns {
+			t.Errorf("%d trains: predicted %d turns, simulated %d", tc.trains, turns, got)
+		}
+	}
+	// Both paths are there to be had
+	if got := FindOptimalPaths(g, "s", "t", 2); len(got) != 2 {
+		t.Errorf("found %d paths, want 2", len(got))
+	}
+}
+
+// The functions without a graph count every connection as one turn, as they
+// did before maps had weights.
+func TestUnweightedEntryPoints(t *testing.T) {
+	london, err := ParseMapFile("../testdata/London.map")
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := FindOptimalPaths(london, "waterloo", "st_pancras", 4)
+	if got, want := Simulate(AssignToPipelines(paths, 4)), london.Simulate(london.AssignToPipelines(paths, 4)); !reflect.DeepEqual(got, want) {
+		t.Errorf("unweighted map: Simulate gives %+v, Graph.Simulate %+v", got, want)
+	}
+
+	weighted, err := ParseMapFile("../testdata/weighted.map")
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths = FindOptimalPaths(weighted, "depot", "harbour", 6)
+	if got, want := Simulate(AssignToPipelines(paths, 6)).TotalTurns, PredictTurns(paths, 6); got != want {
+		t.Errorf("one turn per connection: simulated %d turns, predicted %d", got, want)
+	}
+	if got, want := weighted.Simulate(weighted.AssignToPipelines(paths, 6)).TotalTurns, weighted.PredictTurns(paths, 6); got != want {
+		t.Errorf("weighted: simulated %d turns, predicted %d", got, want)
+	}
+	if PredictTurns(paths, 6) >= weighted.PredictTurns(paths, 6) {
+		t.Errorf("weights should make the trip longer: %d and %d turns", PredictTurns(paths, 6), weighted.PredictTurns(paths, 6))
+	}
+}
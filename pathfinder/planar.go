@@ -0,0 +1,290 @@
+package pathfinder
+
+import (
+	"math"
+	"slices"
+	"sort"
+)
+
+// ---- Planar Connections ----
+
+// planarConnections connects stations to nearby stations, shortest first,
+// skipping any connection that would cross one already laid, then joins
+// what is left apart the same way. Candidates are each station's degree
+// nearest stations, or every station within radius when it is set.
+func planarConnections(edges *edgeSet, coords [][2]int, degree, radius int) {
+	n := len(coords)
+	points := newPointIndex(coords)
+	var candidates [][2]int
+	for a := range coords {
+		var near []int
+		if radius > 0 {
+			near = points.within(a, float64(radius))
+		} else {
+			near = points.closest(a, degree)
+		}
+		for _, b := range near {
+			candidates = append(candidates, [2]int{a, b})
+		}
+	}
+	sortByLength(candidates, coords)
+
+	segments := newSegmentIndex(coords)
+	for _, c := range candidates {
+		if !edges.has(c[0], c[1]) && !segments.crosses(c[0], c[1]) {
+			edges.add(c[0], c[1])
+			segments.add(c[0], c[1])
+		}
+	}
+
+	// Join the groups of stations that cannot reach each other with the
+	// shortest connections between groups that cross nothing, looking
+	// further afield until every group is joined. Every straight-line map
+	// can be completed to a triangulation without crossings, so once all
+	// pairs are tried the map is connected; only a track running through a
+	// station could stop that, and then the shortest link is taken anyway.
+	group := components(edges, n)
+	parent := make([]int, n)
+	for i := range parent {
+		parent[i] = i
+	}
+	find := func(a int) int {
+		for group[a] != parent[group[a]] {
+			parent[group[a]] = parent[parent[group[a]]]
+			group[a] = parent[group[a]]
+		}
+		return group[a]
+	}
+	groups := slices.Max(group) + 1
+	join := func(l [2]int) {
+		edges.add(l[0], l[1])
+		segments.add(l[0], l[1])
+		parent[find(l[0])] = find(l[1])
+		groups--
+	}
+	for k := 2 * max(degree, 4); groups > 1; k *= 2 {
+		var links [][2]int
+		for a := range coords {
+			for _, b := range points.closest(a, k) {
+				if a < b && find(a) != find(b) {
+					links = append(links, [2]int{a, b})
+				}
+			}
+		}
+		sortByLength(links, coords)
+		for _, l := range links {
+			if find(l[0]) != find(l[1]) && !segments.crosses(l[0], l[1]) {
+				join(l)
+			}
+		}
+		if k >= n {
+			for _, l := range links {
+				if find(l[0]) != find(l[1]) {
+					join(l)
+				}
+			}
+		}
+	}
+}
+
+// pointIndex finds nearby stations through a grid of square cells, each
+// listing the stations inside it.
+type pointIndex struct {
+	coords [][2]int
+	cell   int
+	cells  map[[2]int][]int
+	rings  int // rings of cells around any cell that cover the whole map
+}
+
+func newPointIndex(coords [][2]int) *pointIndex {
+	x := &pointIndex{coords: coords, cell: cellSize(coords), cells: make(map[[2]int][]int)}
+	minX, minY, maxX, maxY := bounds(coords)
+	x.rings = max(maxX/x.cell-minX/x.cell, maxY/x.cell-minY/x.cell)
+	for id, c := range coords {
+		cell := [2]int{c[0] / x.cell, c[1] / x.cell}
+		x.cells[cell] = append(x.cells[cell], id)
+	}
+	return x
+}
+
+// ring calls fn for every station in the cells r cells away from the cell
+// of a, measured along either axis.
+func (x *pointIndex) ring(a, r int, fn func(b int)) {
+	cx, cy := x.coords[a][0]/x.cell, x.coords[a][1]/x.cell
+	for dx := -r; dx <= r; dx++ {
+		for dy := -r; dy <= r; dy++ {
+			if max(dx, -dx, dy, -dy) != r {
+				continue
+			}
+			for _, b := range x.cells[[2]int{cx + dx, cy + dy}] {
+				fn(b)
+			}
+		}
+	}
+}
+
+// closest returns the k stations closest to a, closest first. Ties go to
+// the lower index.
+func (x *pointIndex) closest(a, k int) []int {
+	var best []int
+	before := func(b, c int) bool {
+		db, dc := distance(x.coords[a], x.coords[b]), distance(x.coords[a], x.coords[c])
+		return db < dc || db == dc && b < c
+	}
+	for r := 0; r <= x.rings; r++ {
+		x.ring(a, r, func(b int) {
+			if b == a {
+				return
+			}
+			i := len(best)
+			for i > 0 && before(b, best[i-1]) {
+				i--
+			}
+			if i < k {
+				best = slices.Insert(best, i, b)[:min(len(best)+1, k)]
+			}
+		})
+		// Stations further out are at least r cells away
+		if len(best) == k && distance(x.coords[a], x.coords[best[k-1]]) <= float64(r*x.cell) {
+			break
+		}
+	}
+	return best
+}
+
+// within returns every station at most radius from a.
+func (x *pointIndex) within(a int, radius float64) []int {
+	var near []int
+	for r := 0; r <= x.rings && float64((r-1)*x.cell) <= radius; r++ {
+		x.ring(a, r, func(b int) {
+			if b != a && distance(x.coords[a], x.coords[b]) <= radius {
+				near = append(near, b)
+			}
+		})
+	}
+	return near
+}
+
+// cellSize is the side of grid cells holding about one station each.
+func cellSize(coords [][2]int) int {
+	minX, minY, maxX, maxY := bounds(coords)
+	side := max(maxX-minX, maxY-minY, 1)
+	return max(side/int(math.Ceil(math.Sqrt(float64(len(coords))))), 1)
+}
+
+// sortByLength sorts pairs shortest first, ties by station index so the
+// order does not depend on the sort.
+func sortByLength(pairs [][2]int, coords [][2]int) {
+	sort.Slice(pairs, func(i, j int) bool {
+		di, dj := distance(coords[pairs[i][0]], coords[pairs[i][1]]), distance(coords[pairs[j][0]], coords[pairs[j][1]])
+		if di != dj {
+			return di < dj
+		}
+		if pairs[i][0] != pairs[j][0] {
+			return pairs[i][0] < pairs[j][0]
+		}
+		return pairs[i][1] < pairs[j][1]
+	})
+}
+
+// segmentIndex finds the connections laid near a new one through a grid
+// of square cells, each listing the connections whose bounding box
+// touches it.
+type segmentIndex struct {
+	coords   [][2]int
+	cell     int
+	cells    map[[2]int][]int
+	segments [][2]int
+	seen     []int // query stamp per segment, so each is tested once
+	query    int
+}
+
+func newSegmentIndex(coords [][2]int) *segmentIndex {
+	return &segmentIndex{coords: coords, cell: cellSize(coords), cells: make(map[[2]int][]int)}
+}
+
+// box calls fn for every cell the bounding box of a-b touches.
+func (x *segmentIndex) box(a, b int, fn func(cell [2]int)) {
+	p, q := x.coords[a], x.coords[b]
+	for cx := min(p[0], q[0]) / x.cell; cx <= max(p[0], q[0])/x.cell; cx++ {
+		for cy := min(p[1], q[1]) / x.cell; cy <= max(p[1], q[1])/x.cell; cy++ {
+			fn([2]int{cx, cy})
+		}
+	}
+}
+
+func (x *segmentIndex) add(a, b int) {
+	id := len(x.segments)
+	x.segments = append(x.segments, [2]int{a, b})
+	x.seen = append(x.seen, 0)
+	x.box(a, b, func(cell [2]int) { x.cells[cell] = append(x.cells[cell], id) })
+}
+
+// crosses reports whether a-b would cross a connection already laid.
+func (x *segmentIndex) crosses(a, b int) bool {
+	x.query++
+	found := false
+	x.box(a, b, func(cell [2]int) {
+		for _, id := range x.cells[cell] {
+			if found || x.seen[id] == x.query {
+				continue
+			}
+			x.seen[id] = x.query
+			s := x.segments[id]
+			found = segmentsCross(x.coords[a], x.coords[b], x.coords[s[0]], x.coords[s[1]])
+		}
+	})
+	return found
+}
+
+// segmentsCross reports whether segments p1-p2 and q1-q2 meet anywhere but
+// at a station they share. Touching counts: a track may not run through
+// another track's station or along another track.
+func segmentsCross(p1, p2, q1, q2 [2]int) bool {
+	switch {
+	case p1 == q1:
+		return overlaps(p1, p2, q2)
+	case p1 == q2:
+		return overlaps(p1, p2, q1)
+	case p2 == q1:
+		return overlaps(p2, p1, q2)
+	case p2 == q2:
+		return overlaps(p2, p1, q1)
+	}
+	o1, o2 := orientation(p1, p2, q1), orientation(p1, p2, q2)
+	o3, o4 := orientation(q1, q2, p1), orientation(q1, q2, p2)
+	if o1*o2 < 0 && o3*o4 < 0 {
+		return true
+	}
+	return o1 == 0 && onSegment(p1, p2, q1) || o2 == 0 && onSegment(p1, p2, q2) ||
+		o3 == 0 && onSegment(q1, q2, p1) || o4 == 0 && onSegment(q1, q2, p2)
+}
+
+// overlaps reports whether segments from the shared point s to a and to b
+// run along each other.
+func overlaps(s, a, b [2]int) bool {
+	if orientation(s, a, b) != 0 {
+		return false
+	}
+	return (a[0]-s[0])*(b[0]-s[0])+(a[1]-s[1])*(b[1]-s[1]) > 0
+}
+
+// orientation is the sign of the turn from a-b to a-c: 1 counterclockwise,
+// -1 clockwise, 0 when the three points are on one line.
+func orientation(a, b, c [2]int) int {
+	cross := int64(b[0]-a[0])*int64(c[1]-a[1]) - int64(b[1]-a[1])*int64(c[0]-a[0])
+	switch {
+	case cross > 0:
+		return 1
+	case cross < 0:
+		return -1
+	}
+	return 0
+}
+
+// onSegment reports whether c, on the line through a and b, lies between
+// them.
+func onSegment(a, b, c [2]int) bool {
+	return min(a[0], b[0]) <= c[0] && c[0] <= max(a[0], b[0]) &&
+		min(a[1], b[1]) <= c[1] && c[1] <= max(a[1], b[1])
+}
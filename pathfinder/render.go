@@ -0,0 +1,45 @@
+package pathfinder
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	red    = "\033[31m"
+	green  = "\033[32m"
+	yellow = "\033[33m"
+	reset  = "\033[0m"
+)
+
+// RenderSchedule writes the schedule in the console format,
+// one "Turn N: T1-station T2-station" line per turn.
+func RenderSchedule(w io.Writer, schedule *Schedule) error {
+	if _, err := fmt.Fprintln(w, green+"Train movement:"+reset); err != nil {
+		return err
+	}
+	for _, turn := range schedule.Turns {
+		moves := make([]string, len(turn.Moves))
+		for i, move := range turn.Moves {
+			moves[i] = fmt.Sprintf("%s-%s", move.Train, move.To)
+		}
+		if _, err := fmt.Fprintf(w, yellow+"Turn %d:"+reset+" %s\n", turn.Number, strings.Join(moves, " ")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RenderConflicts writes the conflicts the simulator avoided, one per line.
+func RenderConflicts(w io.Writer, schedule *Schedule) error {
+	if _, err := fmt.Fprintf(w, "\n"+red+"Conflicts (%s, %d):"+reset+"\n", schedule.Policy, len(schedule.Conflicts)); err != nil {
+		return err
+	}
+	for _, conflict := range schedule.Conflicts {
+		if _, err := fmt.Fprintln(w, conflict); err != nil {
+			return err
+		}
+	}
+	return nil
+}
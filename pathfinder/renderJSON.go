@@ -0,0 +1,246 @@
+package pathfinder
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"slices"
+)
+
+// ---- JSON Output ----
+
+// SchemaVersion is the version of every JSON document written by this
+// package, in its "schema" field. Fields may be added within a version; it
+// changes when a field is removed or changes meaning.
+const SchemaVersion = 1
+
+// RouteReport is a route as JSON: the paths chosen, the path each train
+// was given and every turn of the schedule.
+type RouteReport struct {
+	Schema    int               `json:"schema"`
+	Type      string            `json:"type"` // "route"
+	Start     string            `json:"start"`
+	End       string            `json:"end"`
+	Paths     []PathReport      `json:"paths"`
+	Trains    []TrainReport     `json:"trains"`
+	Turns     []TurnReport      `json:"turns"`
+	Totals    TotalsReport      `json:"totals"`
+	Policy    string            `json:"policy"`              // track rule, see ConflictPolicy
+	Conflicts []ViolationReport `json:"conflicts,omitempty"` // trains held back, SimulateWithPolicy only
+}
+
+// PathReport is one path and the trains sent along it.
+type PathReport struct {
+	Stations []string `json:"stations"` // start and end included
+	Turns    int      `json:"turns"`    // turns one train takes, see Graph.PathWeight
+	Length   float64  `json:"length"`   // straight-line length, see Graph.PathLength
+	Trains   int      `json:"trains"`   // trains sent along the path
+}
+
+// TrainReport names the path, by index in RouteReport.Paths, a train took.
+// Path is -1 for a train whose path is not one of the paths reported.
+type TrainReport struct {
+	Train string `json:"train"` // train name, such as "T1"
+	Path  int    `json:"path"`
+}
+
+// TurnReport is every move that ends in one turn.
+type TurnReport struct {
+	Schema int          `json:"schema,omitempty"` // ndjson records only
+	Type   string       `json:"type,omitempty"`   // "turn", ndjson records only
+	Turn   int          `json:"turn"`             // from 1
+	Moves  []MoveReport `json:"moves"`            // empty while trains are only under way
+}
+
+// MoveReport is one train arriving at To from From, see Move.
+type MoveReport struct {
+	Train string `json:"train"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+}
+
+// TotalsReport sums up the schedule. PredictedTurns is left out when no
+// prediction was made, as for paths that share stations.
+type TotalsReport struct {
+	Schema         int    `json:"schema,omitempty"` // ndjson records only
+	Type           string `json:"type,omitempty"`   // "totals", ndjson records only
+	Turns          int    `json:"turns"`
+	PredictedTurns int    `json:"predicted_turns,omitempty"`
+	Moves          int    `json:"moves"`
+	Trains         int    `json:"trains"`
+	Arrived        int    `json:"arrived"`
+	TrainsPerPath  []int  `json:"trains_per_path"`
+}
+
+// ViolationReport is a train held back by the track rule, see Violation.
+type ViolationReport struct {
+	Schema  int    `json:"schema,omitempty"` // ndjson records only
+	Type    string `json:"type,omitempty"`   // "conflict", ndjson records only
+	Turn    int    `json:"turn"`
+	Train   string `json:"train"`
+	Rule    Rule   `json:"rule"` // such as "edge-occupied"
+	Message string `json:"message"`
+}
+
+// NewRouteReport describes trains, as given paths by AssignToPipelines,
+// and the schedule simulated for them. predicted is 0 if unknown.
+func NewRouteReport(graph *Graph, paths [][]string, trains []*Train, predicted int, schedule *Schedule) *RouteReport {
+	r := &RouteReport{
+		Schema: SchemaVersion,
+		Type:   "route",
+		Start:  schedule.Start,
+		End:    schedule.End,
+		Paths:  make([]PathReport, len(paths)),
+		Trains: make([]TrainReport, len(trains)),
+		Turns:  make([]TurnReport, len(schedule.Turns)),
+		Policy: schedule.Policy.String(),
+		Totals: TotalsReport{
+			Turns:          schedule.TotalTurns,
+			PredictedTurns: predicted,
+			Moves:          schedule.TotalMoves,
+			Trains:         len(trains),
+			Arrived:        schedule.Arrived,
+			TrainsPerPath:  make([]int, len(paths)),
+		},
+	}
+	if len(paths) > 0 && r.Start == "" {
+		r.Start, r.End = paths[0][0], paths[0][len(paths[0])-1]
+	}
+	for i, path := range paths {
+		r.Paths[i] = PathReport{Stations: path, Turns: graph.PathWeight(path), Length: graph.PathLength(path)}
+	}
+	for i, train := range trains {
+		path := slices.IndexFunc(paths, func(p []string) bool { return slices.Equal(p, train.Path) })
+		r.Trains[i] = TrainReport{Train: train.Name, Path: path}
+		if path >= 0 {
+			r.Paths[path].Trains++
+			r.Totals.TrainsPerPath[path]++
+		}
+	}
+	for i, turn := range schedule.Turns {
+		moves := make([]MoveReport, len(turn.Moves))
+		for j, m := range turn.Moves {
+			moves[j] = MoveReport{Train: m.Train, From: m.From, To: m.To}
+		}
+		r.Turns[i] = TurnReport{Turn: turn.Number, Moves: moves}
+	}
+	for _, v := range schedule.Conflicts {
+		r.Conflicts = append(r.Conflicts, ViolationReport{Turn: v.Turn, Train: v.Train, Rule: v.Rule, Message: v.Message})
+	}
+	return r
+}
+
+// WriteJSON writes the report as one indented JSON document.
+func (r *RouteReport) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// WriteNDJSON writes the report as newline-delimited JSON records, each
+// with "schema" and "type": first a "route" record with the paths and
+// trains, then one "turn" record per turn, one "conflict" record per
+// conflict and last a "totals" record.
+func (r *RouteReport) WriteNDJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	// The outer fields hide those of the report, leaving them out
+	if err := enc.Encode(struct {
+		*RouteReport
+		Turns     *struct{} `json:"turns,omitempty"`
+		Totals    *struct{} `json:"totals,omitempty"`
+		Conflicts *struct{} `json:"conflicts,omitempty"`
+	}{RouteReport: r}); err != nil {
+		return err
+	}
+	for _, turn := range r.Turns {
+		turn.Schema, turn.Type = SchemaVersion, "turn"
+		if err := enc.Encode(turn); err != nil {
+			return err
+		}
+	}
+	for _, v := range r.Conflicts {
+		v.Schema, v.Type = SchemaVersion, "conflict"
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+	}
+	totals := r.Totals
+	totals.Schema, totals.Type = SchemaVersion, "totals"
+	return enc.Encode(totals)
+}
+
+// ---- JSON Errors ----
+
+// ErrorReport is one error as JSON. Code is stable across versions, see
+// ErrorCode; File, Line, Column and Token are set for map file problems
+// that have them.
+type ErrorReport struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	File    string `json:"file,omitempty"`
+	Line    int    `json:"line,omitempty"`
+	Column  int    `json:"column,omitempty"`
+	Token   string `json:"token,omitempty"`
+}
+
+// errorCodes gives each kind of map file problem its code.
+var errorCodes = []struct {
+	err  error
+	code string
+}{
+	{ErrCannotOpen, "cannot-open"},
+	{ErrMissingSection, "missing-section"},
+	{ErrTooManyStations, "too-many-stations"},
+	{ErrInvalidStation, "invalid-station"},
+	{ErrInvalidName, "invalid-name"},
+	{ErrInvalidCoordinates, "invalid-coordinates"},
+	{ErrInvalidCapacity, "invalid-capacity"},
+	{ErrDuplicateStation, "duplicate-station"},
+	{ErrDuplicateCoordinates, "duplicate-coordinates"},
+	{ErrInvalidConnection, "invalid-connection"},
+	{ErrUnknownStation, "unknown-station"},
+	{ErrDuplicateConnection, "duplicate-connection"},
+	{ErrInvalidWeight, "invalid-weight"},
+	{ErrTooManyConnections, "too-many-connections"},
+	{ErrLineTooLong, "line-too-long"},
+	{ErrFileTooLarge, "file-too-large"},
+}
+
+// ErrorCode returns the code of a map file problem, such as
+// "duplicate-station" for ErrDuplicateStation, or "error" for any other
+// error.
+func ErrorCode(err error) string {
+	for _, c := range errorCodes {
+		if errors.Is(err, c.err) {
+			return c.code
+		}
+	}
+	return "error"
+}
+
+// NewErrorReports describes err, one report per MapError in it.
+func NewErrorReports(err error) []ErrorReport {
+	var mapErrs MapErrors
+	if !errors.As(err, &mapErrs) {
+		var mapErr *MapError
+		if !errors.As(err, &mapErr) {
+			return []ErrorReport{{Code: ErrorCode(err), Message: err.Error()}}
+		}
+		mapErrs = MapErrors{mapErr}
+	}
+	reports := make([]ErrorReport, len(mapErrs))
+	for i, e := range mapErrs {
+		reports[i] = ErrorReport{ErrorCode(e), e.Msg, e.File, e.Line, e.Column, e.Token}
+	}
+	return reports
+}
+
+// WriteErrorsJSON writes errors as one line of JSON:
+// {"schema":1,"type":"error","errors":[...]}, which is also an ndjson record.
+func WriteErrorsJSON(w io.Writer, reports []ErrorReport) error {
+	return json.NewEncoder(w).Encode(struct {
+		Schema int           `json:"schema"`
+		Type   string        `json:"type"`
+		Errors []ErrorReport `json:"errors"`
+	}{SchemaVersion, "error", reports})
+}
@@ -0,0 +1,114 @@
+package pathfinder
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestRouteReportJSON(t *testing.T) {
+	graph, err := ParseMapFile("../testdata/London.map")
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths, turns := FindFastestPaths(graph, "waterloo", "st_pancras", 5)
+	trains := graph.AssignToPipelines(paths, 5)
+	report := NewRouteReport(graph, paths, trains, turns, graph.Simulate(trains))
+
+	var buf bytes.Buffer
+	if err := report.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var got RouteReport
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Schema != SchemaVersion || got.Type != "route" || got.Start != "waterloo" || got.End != "st_pancras" {
+		t.Errorf("unexpected header: %+v", got)
+	}
+	if len(got.Paths) != len(paths) || len(got.Trains) != 5 || len(got.Turns) != got.Totals.Turns {
+		t.Errorf("%d paths, %d trains and %d turns, want %d, 5 and %d", len(got.Paths), len(got.Trains), len(got.Turns), len(paths), got.Totals.Turns)
+	}
+	sent, moves := 0, 0
+	for i, p := range got.Paths {
+		if p.Trains != got.Totals.TrainsPerPath[i] {
+			t.Errorf("path %d has %d trains, totals say %d", i, p.Trains, got.Totals.TrainsPerPath[i])
+		}
+		sent += p.Trains
+	}
+	for _, turn := range got.Turns {
+		moves += len(turn.Moves)
+	}
+	if sent != 5 || moves != got.Totals.Moves || got.Totals.PredictedTurns != turns || got.Totals.Arrived != 5 {
+		t.Errorf("%d trains sent and %d moves, totals %+v", sent, moves, got.Totals)
+	}
+	for _, train := range got.Trains {
+		if train.Path < 0 || train.Path >= len(got.Paths) {
+			t.Errorf("train %s has path %d", train.Train, train.Path)
+		}
+	}
+
+	// ndjson: a route record, a record per turn and the totals
+	buf.Reset()
+	if err := report.WriteNDJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var types []string
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var record struct {
+			Schema int
+			Type   string
+			Turns  json.RawMessage
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("%v: %s", err, scanner.Bytes())
+		}
+		if record.Schema != SchemaVersion {
+			t.Errorf("record without schema: %s", scanner.Bytes())
+		}
+		if record.Type == "route" && record.Turns != nil {
+			t.Errorf("route record holds the turns: %s", scanner.Bytes())
+		}
+		types = append(types, record.Type)
+	}
+	if len(types) != len(got.Turns)+2 || types[0] != "route" || types[1] != "turn" || types[len(types)-1] != "totals" {
+		t.Errorf("unexpected records %v", types)
+	}
+}
+
+func TestErrorReports(t *testing.T) {
+	_, errs := ValidateMapFile("../testdata/LondonManyErrors.map", 0)
+	reports := NewErrorReports(errs)
+	want := []string{"invalid-name", "duplicate-station", "duplicate-coordinates", "unknown-station", "duplicate-connection"}
+	if len(reports) != len(want) {
+		t.Fatalf("%d reports, want %d: %+v", len(reports), len(want), reports)
+	}
+	for i, r := range reports {
+		if r.Code != want[i] || r.Line == 0 || r.File == "" || r.Message == "" {
+			t.Errorf("report %d: %+v, want code %s", i, r, want[i])
+		}
+	}
+
+	_, err := ParseMapFile("../testdata/missing.map")
+	if got := NewErrorReports(err); len(got) != 1 || got[0].Code != "cannot-open" {
+		t.Errorf("missing file: %+v", got)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteErrorsJSON(&buf, reports); err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		Schema int
+		Type   string
+		Errors []ErrorReport
+	}
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil || doc.Schema != SchemaVersion || doc.Type != "error" || len(doc.Errors) != len(want) {
+		t.Errorf("%v: %s", err, buf.Bytes())
+	}
+	if bytes.Count(buf.Bytes(), []byte("\n")) != 1 {
+		t.Errorf("expected one line: %s", buf.Bytes())
+	}
+}
@@ -0,0 +1,293 @@
+package pathfinder
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"slices"
+	"strings"
+)
+
+// ---- Scenarios ----
+
+// ScenarioOptions controls GenerateScenario. The same options always give
+// the same scenario.
+type ScenarioOptions struct {
+	Seed     int64 // seed of the random source
+	Routes   []int // connections on each planted route from start to end, each at least 2
+	Trains   int   // trains the optimum is worked out for, one per route if 0
+	Decoys   int   // detours that leave a route and rejoin it further on, always longer
+	DeadEnds int   // spurs of one to three stations that lead nowhere
+	Traps    int   // shortcuts from a longer route into a shorter one, see GenerateScenario
+}
+
+// Scenario is a generated map whose best answer is known.
+type Scenario struct {
+	Graph      *Graph
+	Start, End string
+	Trains     int
+	Routes     [][]string // the planted routes, in the order of ScenarioOptions.Routes
+	Paths      int        // most station-disjoint paths from Start to End: one per route
+	Used       int        // routes the optimum needs for Trains trains
+	Turns      int        // turns the optimum takes
+}
+
+// GenerateScenario builds a map with exactly len(opts.Routes) station-disjoint
+// routes from "start" to "end", then adds the extra structure:
+//
+//   - a decoy leaves a route and rejoins it further on, by a longer way;
+//   - a dead end hangs off start or a route and reaches nothing;
+//   - a trap connects station p of a longer route to station p+1 of a
+//     shorter one. Searching from the longer route's first station, the
+//     shortcut is the quickest way to end, and taking it blocks the shorter
+//     route, so a greedy search finds one path fewer. The shorter route's
+//     first station gets a one-station dead end so that searches trying the
+//     least connected neighbours of start first fall for it.
+//
+// None of these make a path to end shorter than the route it finishes on,
+// and every path finishes on a different route, so the optimum is always to
+// run the trains over the shortest planted routes.
+func GenerateScenario(opts ScenarioOptions) (*Scenario, error) {
+	if len(opts.Routes) == 0 {
+		return nil, fmt.Errorf("a scenario needs at least one route")
+	}
+	for _, length := range opts.Routes {
+		if length < 2 {
+			return nil, fmt.Errorf("invalid route length: %d (must be at least 2)", length)
+		}
+	}
+	if opts.Trains < 0 || opts.Decoys < 0 || opts.DeadEnds < 0 || opts.Traps < 0 {
+		return nil, fmt.Errorf("trains, decoys, dead ends and traps must not be negative")
+	}
+	trains := opts.Trains
+	if trains == 0 {
+		trains = len(opts.Routes)
+	}
+	rng := rand.New(rand.NewSource(opts.Seed))
+	b := newScenarioBuilder(rng, opts.Routes)
+
+	for i := 0; i < opts.Decoys; i++ {
+		if err := b.addDecoy(); err != nil {
+			return nil, err
+		}
+	}
+	for i := 0; i < opts.DeadEnds; i++ {
+		anchors := slices.Concat([]int{b.start}, b.interior())
+		b.addDeadEnd(anchors[rng.Intn(len(anchors))], 1+rng.Intn(3))
+	}
+	if err := b.addTraps(opts.Traps); err != nil {
+		return nil, err
+	}
+
+	s := &Scenario{Graph: buildGeneratedGraph(b.names, b.coords, b.edges.pairs), Start: "start", End: "end", Trains: trains, Paths: len(opts.Routes)}
+	for _, route := range b.routes {
+		names := make([]string, len(route))
+		for i, id := range route {
+			names[i] = b.names[id]
+		}
+		s.Routes = append(s.Routes, names)
+	}
+	s.Used, s.Turns = optimum(opts.Routes, trains)
+	return s, nil
+}
+
+// optimum returns how many of the given disjoint routes the fastest
+// schedule uses and the turns it takes. With the m shortest routes the
+// trains can finish on turn T once the routes together deliver
+// sum(T-L+1) trains, and T must be at least the longest of them.
+func optimum(routes []int, trains int) (used, turns int) {
+	lengths := slices.Sorted(slices.Values(routes))
+	sum := 0
+	for m := 1; m <= len(lengths); m++ {
+		sum += lengths[m-1] - 1
+		t := max(lengths[m-1], (trains+sum+m-1)/m)
+		// Ties keep the smaller set
+		if used == 0 || t < turns {
+			used, turns = m, t
+		}
+	}
+	return used, turns
+}
+
+// scenarioBuilder collects the stations and connections of a scenario.
+// Routes run left to right, one lane each, with start and end at the sides.
+type scenarioBuilder struct {
+	rng        *rand.Rand
+	names      []string
+	coords     [][2]int
+	used       map[[2]int]bool
+	edges      *edgeSet
+	start, end int
+	routes     [][]int // station IDs, start and end included
+	extras     int     // decoys and dead ends added, for their names
+	spurs      map[int]bool
+}
+
+const laneSpacing = 40
+
+func newScenarioBuilder(rng *rand.Rand, lengths []int) *scenarioBuilder {
+	b := &scenarioBuilder{rng: rng, used: make(map[[2]int]bool), edges: newEdgeSet(0), spurs: make(map[int]bool)}
+	width := slices.Max(lengths) * laneSpacing
+	middle := laneSpacing + (len(lengths)-1)*laneSpacing/2
+	b.start = b.station("start", [2]int{0, middle})
+	b.end = b.station("end", [2]int{width, middle})
+	for i, length := range lengths {
+		route := []int{b.start}
+		for j := 1; j < length; j++ {
+			route = append(route, b.station(fmt.Sprintf("r%d_%d", i+1, j), [2]int{j * width / length, laneSpacing * (i + 1)}))
+		}
+		route = append(route, b.end)
+		for j := 1; j < len(route); j++ {
+			b.edges.add(route[j-1], route[j])
+		}
+		b.routes = append(b.routes, route)
+	}
+	return b
+}
+
+// station adds a station at the first free point at or after at.
+func (b *scenarioBuilder) station(name string, at [2]int) int {
+	for b.used[at] {
+		at[0]++
+	}
+	b.used[at] = true
+	b.names = append(b.names, name)
+	b.coords = append(b.coords, at)
+	return len(b.names) - 1
+}
+
+// near returns a point a few units from at, never negative.
+func (b *scenarioBuilder) near(at [2]int) [2]int {
+	jitter := laneSpacing / 3
+	return [2]int{
+		max(at[0]+b.rng.Intn(2*jitter+1)-jitter, 0),
+		max(at[1]+b.rng.Intn(2*jitter+1)-jitter, 0),
+	}
+}
+
+// interior returns the stations of every route except start and end.
+func (b *scenarioBuilder) interior() []int {
+	var ids []int
+	for _, route := range b.routes {
+		ids = append(ids, route[1:len(route)-1]...)
+	}
+	return ids
+}
+
+// addDecoy joins stations a < z of one route, neither start nor end, by a
+// new way at least one connection longer than the route between them.
+func (b *scenarioBuilder) addDecoy() error {
+	var candidates []int
+	for i, route := range b.routes {
+		if len(route) > 3 {
+			candidates = append(candidates, i)
+		}
+	}
+	if len(candidates) == 0 {
+		return fmt.Errorf("decoys need a route of at least 3 connections")
+	}
+	route := b.routes[candidates[b.rng.Intn(len(candidates))]]
+	a := 1 + b.rng.Intn(len(route)-3)
+	z := a + 1 + b.rng.Intn(len(route)-2-a)
+	length := z - a + 1 + b.rng.Intn(2)
+
+	b.extras++
+	from, to := b.coords[route[a]], b.coords[route[z]]
+	prev := route[a]
+	for j := 1; j < length; j++ {
+		// Bow out halfway to the next lane
+		at := [2]int{from[0] + (to[0]-from[0])*j/length, from[1] + laneSpacing/2}
+		id := b.station(fmt.Sprintf("d%d_%d", b.extras, j), b.near(at))
+		b.edges.add(prev, id)
+		prev = id
+	}
+	b.edges.add(prev, route[z])
+	return nil
+}
+
+// addDeadEnd hangs a chain of length new stations off anchor.
+func (b *scenarioBuilder) addDeadEnd(anchor, length int) {
+	b.extras++
+	prev := anchor
+	for j := 1; j <= length; j++ {
+		id := b.station(fmt.Sprintf("x%d_%d", b.extras, j), b.near(b.coords[prev]))
+		b.edges.add(prev, id)
+		prev = id
+	}
+}
+
+// addTraps adds n shortcuts, picked at random among every possible one.
+func (b *scenarioBuilder) addTraps(n int) error {
+	type trap struct{ long, short, p int }
+	var candidates []trap
+	for i, long := range b.routes {
+		for j, short := range b.routes {
+			// Station p+1 of the shorter route must not be end
+			for p := 1; len(long) > len(short) && p+1 < len(short)-1; p++ {
+				candidates = append(candidates, trap{i, j, p})
+			}
+		}
+	}
+	if n > len(candidates) {
+		return fmt.Errorf("only %d traps fit these routes; traps need a route of at least 3 connections and a longer one", len(candidates))
+	}
+	b.rng.Shuffle(len(candidates), func(i, j int) { candidates[i], candidates[j] = candidates[j], candidates[i] })
+	for _, t := range candidates[:n] {
+		long, short := b.routes[t.long], b.routes[t.short]
+		b.edges.add(long[t.p], short[t.p+1])
+		if !b.spurs[short[1]] {
+			b.spurs[short[1]] = true
+			b.addDeadEnd(short[1], 1)
+		}
+	}
+	return nil
+}
+
+// WriteScenario writes what is known about a scenario's best answer, as
+// "key: value" lines followed by one "route:" line per planted route.
+func WriteScenario(w io.Writer, s *Scenario) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "start: %s\nend: %s\ntrains: %d\n", s.Start, s.End, s.Trains)
+	fmt.Fprintf(&b, "paths: %d\nused: %d\nturns: %d\n", s.Paths, s.Used, s.Turns)
+	for _, route := range s.Routes {
+		fmt.Fprintf(&b, "route: %s\n", strings.Join(route, "-"))
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// GenerateScenarioFiles writes a scenario to mapFile, adding the .map
+// extension if it is missing, and its optimum next to it with the .optimum
+// extension. It returns the names of both files.
+func GenerateScenarioFiles(mapFile string, opts ScenarioOptions) (string, string, error) {
+	s, err := GenerateScenario(opts)
+	if err != nil {
+		return "", "", err
+	}
+	if !strings.HasSuffix(mapFile, ".map") {
+		mapFile += ".map"
+	}
+	optimumFile := strings.TrimSuffix(mapFile, ".map") + ".optimum"
+
+	for _, file := range []struct {
+		name  string
+		write func(io.Writer) error
+	}{
+		{mapFile, func(w io.Writer) error { return WriteMap(w, s.Graph) }},
+		{optimumFile, func(w io.Writer) error { return WriteScenario(w, s) }},
+	} {
+		out, err := os.Create(file.name)
+		if err != nil {
+			return "", "", fmt.Errorf("error saving scenario: %w", err)
+		}
+		if err := file.write(out); err != nil {
+			out.Close()
+			return "", "", fmt.Errorf("error saving scenario: %w", err)
+		}
+		if err := out.Close(); err != nil {
+			return "", "", fmt.Errorf("error saving scenario: %w", err)
+		}
+	}
+	return mapFile, optimumFile, nil
+}
@@ -0,0 +1,100 @@
+package pathfinder
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestScenarioOptimum(t *testing.T) {
+	cases := []ScenarioOptions{
+		{Routes: []int{4}, Trains: 3},
+		{Routes: []int{3, 5, 9}, Trains: 4, Decoys: 3, DeadEnds: 4},
+		{Routes: []int{2, 6, 6, 7}, Trains: 12, Decoys: 2, DeadEnds: 2, Traps: 3},
+		{Routes: []int{5, 8}, Trains: 1, Traps: 2},
+		{Routes: []int{4, 5, 6, 12}, Trains: 30, Decoys: 5, DeadEnds: 5, Traps: 5},
+	}
+	for _, opts := range cases {
+		for seed := int64(0); seed < 10; seed++ {
+			opts.Seed = seed
+			s, err := GenerateScenario(opts)
+			if err != nil {
+				t.Fatalf("%+v: %v", opts, err)
+			}
+			if got := len(FindOptimalPaths(s.Graph, s.Start, s.End, 100)); got != s.Paths {
+				t.Errorf("%+v: %d disjoint paths, want %d", opts, got, s.Paths)
+			}
+			paths, turns := FindFastestPaths(s.Graph, s.Start, s.End, s.Trains)
+			if turns != s.Turns || len(paths) != s.Used {
+				t.Errorf("%+v: fastest paths take %d turns on %d paths, want %d on %d", opts, turns, len(paths), s.Turns, s.Used)
+			}
+			schedule := s.Graph.Simulate(s.Graph.AssignToPipelines(paths, s.Trains))
+			if schedule.TotalTurns != s.Turns {
+				t.Errorf("%+v: simulated %d turns, want %d", opts, schedule.TotalTurns, s.Turns)
+			}
+			for _, route := range s.Routes {
+				if route[0] != s.Start || route[len(route)-1] != s.End {
+					t.Errorf("route %v does not run from start to end", route)
+				}
+			}
+		}
+	}
+}
+
+func TestScenarioTrapsCatchGreedySearch(t *testing.T) {
+	caught := 0
+	for seed := int64(0); seed < 20; seed++ {
+		s, err := GenerateScenario(ScenarioOptions{Seed: seed, Routes: []int{5, 8}, Traps: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(FindMultiplePaths(s.Graph, s.Start, s.End, 2)) < s.Paths {
+			caught++
+		}
+	}
+	if caught == 0 {
+		t.Error("no trap caught the greedy search")
+	}
+
+	if _, err := GenerateScenario(ScenarioOptions{Routes: []int{4, 4}, Traps: 1}); err == nil {
+		t.Error("expected an error for traps between routes of the same length")
+	}
+	if _, err := GenerateScenario(ScenarioOptions{Routes: []int{2, 1}}); err == nil {
+		t.Error("expected an error for a route of one connection")
+	}
+}
+
+func TestGenerateScenarioFiles(t *testing.T) {
+	opts := ScenarioOptions{Seed: 5, Routes: []int{3, 4, 6}, Trains: 7, Decoys: 1, DeadEnds: 1, Traps: 1}
+	mapFile, optimumFile, err := GenerateScenarioFiles(t.TempDir()+"/scenario", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(mapFile, "/scenario.map") || !strings.HasSuffix(optimumFile, "/scenario.optimum") {
+		t.Errorf("wrote %s and %s", mapFile, optimumFile)
+	}
+	if _, err := ParseMapFile(mapFile); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := GenerateScenario(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want bytes.Buffer
+	if err := WriteScenario(&want, s); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(optimumFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want.String() {
+		t.Errorf("got\n%s\nwant\n%s", got, want.String())
+	}
+	// Routes 3 and 4 deliver 7 trains by turn 6, which route 6 cannot improve
+	if !strings.Contains(want.String(), "paths: 3\nused: 2\nturns: 6\n") {
+		t.Errorf("unexpected optimum:\n%s", want.String())
+	}
+}
@@ -2,87 +2,386 @@ package pathfinder
 
 import (
 	"fmt"
+	"os"
 	"sort"
-	"strings"
 )
 
-const (
-	red    = "\033[31m"
-	green  = "\033[32m"
-	yellow = "\033[33m"
-	reset  = "\033[0m"
-)
-// movement simulation
-func SimulateMovements(trains []*Train) {
+// ---- Schedule ----
+
+// Move is one train crossing one connection. It is listed in the turn the
+// train arrives; Duration is how many turns the crossing took.
+type Move struct {
+	Train    string
+	From     string
+	To       string
+	Edge     string // key of the track used, see Graph.trackKey
+	Duration int
+}
+
+// Turn holds every move that ends in one turn. Number starts at 1. Turns in
+// which trains are only under way on long connections have no moves.
+type Turn struct {
+	Number int
+	Moves  []Move
+}
+
+// Schedule is the full result of a simulation.
+type Schedule struct {
+	Start      string
+	End        string
+	Turns      []Turn
+	TotalTurns int
+	TotalMoves int
+	Trains     int
+	Arrived    int
+	Policy     ConflictPolicy
+	Conflicts  []Violation // every time a train was held back from a track, SimulateWithPolicy only
+}
+
+// Simulate moves the trains one connection at a time, avoiding collisions,
+// and returns the resulting schedule. Nothing is printed.
+// A train entering a connection of weight N stays on it for N turns; the
+// connection and a place at the station it leads to are held for it until
+// then. Intermediate stations hold as many trains as their capacity.
+// Simulate itself treats every connection as one turn long and every
+// station as holding one train; use Graph.Simulate for a parsed map.
+func Simulate(trains []*Train) *Schedule {
+	var graph *Graph
+	return graph.Simulate(trains)
+}
+
+// Simulate is Simulate with the weights, capacities and one-way tracks of
+// g. A nil graph behaves like Simulate.
+func (g *Graph) Simulate(trains []*Train) *Schedule {
+	return simulate(g, trains, ConflictPolicy{}, false)
+}
+
+// SimulateWithPolicy is Simulate with the track rule given by policy.
+// Whenever a train has to wait for a track, the conflict is recorded in
+// Schedule.Conflicts.
+func SimulateWithPolicy(graph *Graph, trains []*Train, policy ConflictPolicy) *Schedule {
+	return simulate(graph, trains, policy, true)
+}
+
+// simulate runs the simulation; recording conflicts is left to callers that
+// ask for them, as formatting one for every wait is slow.
+func simulate(graph *Graph, trains []*Train, policy ConflictPolicy, record bool) *Schedule {
+	schedule := &Schedule{Trains: len(trains), Policy: policy}
 	if len(trains) == 0 {
-		return
+		return schedule
+	}
+
+	schedule.Start = trains[0].Path[0]
+	schedule.End = trains[0].Path[len(trains[0].Path)-1]
+
+	// The simulation runs on station IDs of the stations the trains use
+	paths := make([][]string, len(trains))
+	for i, train := range trains {
+		paths[i] = train.Path
+	}
+	c := Compile(pathGraph(graph, paths))
+	startStation, _ := c.ID(schedule.Start)
+	endStation, _ := c.ID(schedule.End)
+
+	type simTrain struct {
+		*Train
+		stations []int // path as IDs
+		edges    []int // edge index of each hop, see edgeIndex
+	}
+	sim := make([]simTrain, len(trains))
+	for i, train := range trains {
+		sim[i] = simTrain{Train: train, stations: make([]int, len(train.Path)), edges: make([]int, len(train.Path)-1)}
+		for j, name := range train.Path {
+			sim[i].stations[j], _ = c.ID(name)
+			if j > 0 {
+				sim[i].edges[j-1] = c.edgeIndex(sim[i].stations[j-1], sim[i].stations[j])
+			}
+		}
 	}
 
-	startStation := trains[0].Path[0]
-	endStation := trains[0].Path[len(trains[0].Path)-1]
+	// Paths crossing the same stations in different orders can lock trains
+	// up, each waiting for the station the other holds. Then a train only
+	// leaves start if the stations ahead of every train still follow one
+	// order, which keeps some train always able to move.
+	remaining := make([][]int, 0, len(sim))
+	for _, train := range sim {
+		remaining = append(remaining, train.stations)
+	}
+	checkDepartures := !c.oneOrder(remaining, startStation, endStation)
 
-	occupied := make(map[string]string)
-	occupied[startStation] = ""
+	// Trains at or heading to each intermediate station
+	occupied := make([]int, len(c.Names))
+	// Who holds each track: until when, entered from where and when
+	edgeFreeAt := make([]int, len(c.adj))
+	edgeFrom := make([]int, len(c.adj))
+	edgeEnteredAt := make([]int, len(c.adj))
+	arrived := make([]bool, len(sim))
+	// Kept between turns, each turn's sort starts from the last order
+	order := make([]int, len(sim))
+	for i := range order {
+		order[i] = i
+	}
 
-	turn := 0
-	fmt.Println(green + "Train movement:" + reset)
+	for turn := 1; ; turn++ {
+		var turnMoves []Move
+		underWay := false
 
-	for {
-		moved := false
-		var turnMoves []string
-		usedEdges := make(map[string]bool)
+		// Trains already on a long connection get one turn closer
+		for i, train := range sim {
+			arrived[i] = false
+			if train.Travel == 0 {
+				continue
+			}
+			train.Travel--
+			if train.Travel == 0 {
+				turnMoves = append(turnMoves, arrive(c, train.Train, train.stations, schedule))
+				arrived[i] = true
+			} else {
+				underWay = true
+			}
+		}
 
 		// Process trains furthest along their path first
-		sort.Slice(trains, func(i, j int) bool {
-			return trains[i].Index > trains[j].Index
+		sort.Slice(order, func(i, j int) bool {
+			return sim[order[i]].Index > sim[order[j]].Index
 		})
 
-		for _, train := range trains {
-			if !train.Active || train.Index+1 >= len(train.Path) {
+		for _, i := range order {
+			train := sim[i]
+			if !train.Active || train.Travel > 0 || arrived[i] || train.Index+1 >= len(train.stations) {
 				continue
 			}
 
-			current := train.Path[train.Index]
-			next := train.Path[train.Index+1]
+			current := train.stations[train.Index]
+			next := train.stations[train.Index+1]
+			edge := train.edges[train.Index]
+
+			if checkDepartures && current == startStation {
+				remaining = remaining[:0]
+				for j, other := range sim {
+					// A train on its first connection is as committed as
+					// one past it
+					if other.Active && (other.Travel > 0 || other.Index > 0) {
+						remaining = append(remaining, other.stations[other.Index:])
+					} else if j == i {
+						remaining = append(remaining, other.stations)
+					}
+				}
+				if !c.oneOrder(remaining, startStation, endStation) {
+					continue
+				}
+			}
 
 			// Free up current station if not start
 			if current != startStation {
-				delete(occupied, current)
+				occupied[current]--
 			}
 
-			edgeKey := normalizeEdgeKey(current, next)
-
-			// Check for conflicts: edge already used or next station occupied
-			if usedEdges[edgeKey] || (next != endStation && occupied[next] != "") {
+			// Check for conflicts: track held by another train or next station full
+			free := edgeFreeAt[edge] <= turn ||
+				policy.Mode == DirectionalLock && edgeFrom[edge] == current && edgeEnteredAt[edge] < turn
+			if !free || (next != endStation && occupied[next] >= c.Capacity(next)) {
 				// Re-occupy current if we vacated it
 				if current != startStation {
-					occupied[current] = train.Name
+					occupied[current]++
+				}
+				if !free {
+					// A reserved track may be empty; keep going until it is released
+					underWay = true
+				}
+				if !free && record {
+					schedule.Conflicts = append(schedule.Conflicts, conflict(c, policy, turn, train.Name, current, next, edgeFrom[edge], edgeFreeAt[edge]))
 				}
 				continue
 			}
 
-			// Move train to next station
-			train.Index++
-			if train.Index == len(train.Path)-1 {
-				train.Active = false
+			// Move train towards next station
+			weight := c.weights[edge]
+			edgeFreeAt[edge] = max(edgeFreeAt[edge], turn+policy.hold(weight))
+			edgeFrom[edge] = current
+			edgeEnteredAt[edge] = turn
+			if next != endStation {
+				occupied[next]++
 			}
+			if weight == 1 {
+				turnMoves = append(turnMoves, arrive(c, train.Train, train.stations, schedule))
+			} else {
+				train.Travel = weight - 1
+				underWay = true
+			}
+		}
 
-			usedEdges[edgeKey] = true
-			if next != endStation {
-				occupied[next] = train.Name
+		if len(turnMoves) == 0 && !underWay {
+			break
+		}
+
+		schedule.Turns = append(schedule.Turns, Turn{Number: turn, Moves: turnMoves})
+		schedule.TotalMoves += len(turnMoves)
+	}
+	schedule.TotalTurns = len(schedule.Turns)
+	return schedule
+}
+
+// arrive moves the train onto the next station of its path.
+func arrive(c *CompiledGraph, train *Train, stations []int, schedule *Schedule) Move {
+	from := train.Path[train.Index]
+	edge := c.edgeIndex(stations[train.Index], stations[train.Index+1])
+	weight := c.weights[edge]
+	train.Index++
+	to := train.Path[train.Index]
+	key := normalizeEdgeKey(from, to)
+	if c.isOneWay(edge) {
+		key = directedEdgeKey(from, to)
+	}
+	if train.Index == len(train.Path)-1 {
+		train.Active = false
+		schedule.Arrived++
+	}
+	return Move{Train: train.Name, From: from, To: to, Edge: key, Duration: weight}
+}
+
+// oneOrder reports whether the intermediate stations of the paths can be put
+// in a single order that every path follows, so no trains wait on each other
+// in a circle.
+func (c *CompiledGraph) oneOrder(paths [][]int, start, end int) bool {
+	next := make([][]int, len(c.Names))
+	waitingOn := make([]int, len(c.Names))
+	for _, path := range paths {
+		for k := 1; k < len(path); k++ {
+			from, to := path[k-1], path[k]
+			if from == start || from == end || to == start || to == end {
+				continue
 			}
+			next[from] = append(next[from], to)
+			waitingOn[to]++
+		}
+	}
+	// Kahn's algorithm: the order exists if every station can be taken
+	var ready []int
+	for v := range waitingOn {
+		if waitingOn[v] == 0 {
+			ready = append(ready, v)
+		}
+	}
+	taken := 0
+	for len(ready) > 0 {
+		v := ready[len(ready)-1]
+		ready = ready[:len(ready)-1]
+		taken++
+		for _, w := range next[v] {
+			if waitingOn[w]--; waitingOn[w] == 0 {
+				ready = append(ready, w)
+			}
+		}
+	}
+	return taken == len(c.Names)
+}
+
+// conflict describes a train at from held back from the track to to, last
+// entered from holder and held until freeAt.
+func conflict(c *CompiledGraph, policy ConflictPolicy, turn int, train string, from, to, holder, freeAt int) Violation {
+	rule := policy.rule(holder != from)
+	message := fmt.Sprintf("train %s waits at %q: %q-%q is in use", train, c.Names[from], c.Names[from], c.Names[to])
+	switch rule {
+	case RuleHeadOn:
+		message = fmt.Sprintf("train %s waits at %q: a train is coming from %q", train, c.Names[from], c.Names[to])
+	case RuleBlockReserved:
+		message = fmt.Sprintf("train %s waits at %q: %q-%q is reserved until turn %d", train, c.Names[from], c.Names[from], c.Names[to], freeAt-1)
+	}
+	return Violation{turn, train, rule, message}
+}
 
-			turnMoves = append(turnMoves, fmt.Sprintf("%s-%s", train.Name, next))
-			moved = true
+// pathGraph is the part of graph the paths use: their stations and
+// connections with the capacities, weights and one-way tracks from graph.
+// Paths given more than once, as trains share them, are added once.
+func pathGraph(graph *Graph, paths [][]string) *Graph {
+	g := &Graph{
+		Stations:    make(map[string]*Station),
+		Connections: make(map[string][]string),
+		Weights:     make(map[string]int),
+		OneWay:      make(map[string]bool),
+	}
+	done := make(map[*string]bool)
+	for _, path := range paths {
+		if len(path) == 0 || done[&path[0]] {
+			continue
+		}
+		done[&path[0]] = true
+		for i, name := range path {
+			if _, ok := g.Stations[name]; !ok {
+				g.Stations[name] = &Station{Name: name, Capacity: graph.Capacity(name)}
+			}
+			if i == 0 {
+				continue
+			}
+			prev := path[i-1]
+			key := graph.trackKey(prev, name)
+			if _, ok := g.Weights[key]; ok {
+				continue
+			}
+			g.Weights[key] = graph.Weight(prev, name)
+			g.Connections[prev] = append(g.Connections[prev], name)
+			if graph.IsOneWay(prev, name) {
+				g.OneWay[key] = true
+				continue
+			}
+			g.Connections[name] = append(g.Connections[name], prev)
 		}
+	}
+	return g
+}
 
-		if !moved {
-			break
+// pathGraph is pathGraph for paths of station IDs.
+func (c *CompiledGraph) pathGraph(paths [][]int) *Graph {
+	g := &Graph{
+		Stations:    make(map[string]*Station),
+		Connections: make(map[string][]string),
+		Weights:     make(map[string]int),
+		OneWay:      make(map[string]bool),
+	}
+	for _, path := range paths {
+		for i, v := range path {
+			name := c.Names[v]
+			if _, ok := g.Stations[name]; !ok {
+				g.Stations[name] = &Station{Name: name, Capacity: c.Capacity(v)}
+			}
+			if i == 0 {
+				continue
+			}
+			prev := c.Names[path[i-1]]
+			arc := c.arc(path[i-1], v)
+			key := normalizeEdgeKey(prev, name)
+			if c.isOneWay(arc) {
+				key = directedEdgeKey(prev, name)
+			}
+			if _, ok := g.Weights[key]; ok {
+				continue
+			}
+			g.Weights[key] = c.weights[arc]
+			g.Connections[prev] = append(g.Connections[prev], name)
+			if c.isOneWay(arc) {
+				g.OneWay[key] = true
+				continue
+			}
+			g.Connections[name] = append(g.Connections[name], prev)
 		}
+	}
+	return g
+}
 
-		turn++
-		fmt.Printf(yellow+"Turn %d:"+reset+" %s\n", turn, strings.Join(turnMoves, " "))
+// SimulateMovements runs Simulate and prints the schedule to stdout.
+func SimulateMovements(trains []*Train) {
+	var graph *Graph
+	graph.SimulateMovements(trains)
+}
+
+// SimulateMovements is SimulateMovements with the map of g.
+func (g *Graph) SimulateMovements(trains []*Train) {
+	if len(trains) == 0 {
+		return
 	}
+	// Like fmt.Println, a failed write to stdout is not reported
+	_ = RenderSchedule(os.Stdout, g.Simulate(trains))
 }
 
 // normalizeEdgeKey produces a consistent key for an undirected edge
@@ -91,4 +390,4 @@ func normalizeEdgeKey(a, b string) string {
 		return a + "|" + b
 	}
 	return b + "|" + a
-}
\ No newline at end of file
+}
@@ -0,0 +1,66 @@
+package pathfinder
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestSimulateSchedule(t *testing.T) {
+	paths := [][]string{
+		{"waterloo", "victoria", "st_pancras"},
+		{"waterloo", "euston", "st_pancras"},
+	}
+	schedule := Simulate(AssignToPipelines(paths, 3))
+
+	want := []Turn{
+		{1, []Move{
+			{"T1", "waterloo", "victoria", "victoria|waterloo", 1},
+			{"T2", "waterloo", "euston", "euston|waterloo", 1},
+		}},
+		{2, []Move{
+			{"T1", "victoria", "st_pancras", "st_pancras|victoria", 1},
+			{"T2", "euston", "st_pancras", "euston|st_pancras", 1},
+			{"T3", "waterloo", "victoria", "victoria|waterloo", 1},
+		}},
+		{3, []Move{
+			{"T3", "victoria", "st_pancras", "st_pancras|victoria", 1},
+		}},
+	}
+	if !reflect.DeepEqual(schedule.Turns, want) {
+		t.Errorf("turns:\n%+v\nwant:\n%+v", schedule.Turns, want)
+	}
+	if schedule.Start != "waterloo" || schedule.End != "st_pancras" {
+		t.Errorf("start %q and end %q", schedule.Start, schedule.End)
+	}
+	if schedule.TotalTurns != 3 || schedule.TotalMoves != 6 || schedule.Trains != 3 || schedule.Arrived != 3 {
+		t.Errorf("totals: %d turns, %d moves, %d trains, %d arrived", schedule.TotalTurns, schedule.TotalMoves, schedule.Trains, schedule.Arrived)
+	}
+
+	if empty := Simulate(nil); empty.TotalTurns != 0 || len(empty.Turns) != 0 {
+		t.Errorf("no trains: %+v", empty)
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errors.New("disk full") }
+
+func TestRenderSchedule(t *testing.T) {
+	schedule := Simulate(AssignToPipelines([][]string{{"a", "b", "c"}}, 2))
+	var buf bytes.Buffer
+	if err := RenderSchedule(&buf, schedule); err != nil {
+		t.Fatal(err)
+	}
+	want := green + "Train movement:" + reset + "\n" +
+		yellow + "Turn 1:" + reset + " T1-b\n" +
+		yellow + "Turn 2:" + reset + " T1-c T2-b\n" +
+		yellow + "Turn 3:" + reset + " T2-c\n"
+	if buf.String() != want {
+		t.Errorf("got:\n%q\nwant:\n%q", buf.String(), want)
+	}
+	if err := RenderSchedule(failingWriter{}, schedule); err == nil {
+		t.Error("expected the write error")
+	}
+}
@@ -0,0 +1,109 @@
+package pathfinder
+
+import (
+	"sync"
+)
+
+// ---- Shared Graph ----
+
+// SyncGraph lets one parsed map serve many queries at once. Queries share
+// one compiled form of the graph, built on first use and kept until the
+// next Update; it never changes, so searches hold no lock. Use its methods,
+// not the package functions on the wrapped graph, which race with Update.
+// The zero value is not usable; create one with NewSyncGraph.
+type SyncGraph struct {
+	mu       sync.RWMutex
+	graph    *Graph
+	compiled *CompiledGraph
+}
+
+// NewSyncGraph wraps g. From then on g must only be changed through Update.
+func NewSyncGraph(g *Graph) *SyncGraph {
+	return &SyncGraph{graph: g}
+}
+
+// Read calls fn with the graph under a read lock. fn must not change it.
+func (s *SyncGraph) Read(fn func(g *Graph)) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	fn(s.graph)
+}
+
+// Update calls fn with the graph under the write lock, so it may change it.
+// The compiled form is rebuilt by the next query.
+func (s *SyncGraph) Update(fn func(g *Graph)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(s.graph)
+	s.compiled = nil
+}
+
+// Compiled returns the compiled form of the graph as it is now. It stays
+// valid after an Update, but does not see it.
+func (s *SyncGraph) Compiled() *CompiledGraph {
+	s.mu.RLock()
+	c := s.compiled
+	s.mu.RUnlock()
+	if c != nil {
+		return c
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Another query may have compiled it while we waited
+	if s.compiled == nil {
+		s.compiled = Compile(s.graph)
+	}
+	return s.compiled
+}
+
+// ids looks up start and end in the compiled graph.
+func (s *SyncGraph) ids(start, end string) (*CompiledGraph, int, int, bool) {
+	c := s.Compiled()
+	st, okStart := c.ID(start)
+	e, okEnd := c.ID(end)
+	return c, st, e, okStart && okEnd
+}
+
+// FindMultiplePaths is FindMultiplePathsWithOptions on the shared graph.
+func (s *SyncGraph) FindMultiplePaths(start, end string, maxPaths int, opts PathOptions) [][]string {
+	c, st, e, ok := s.ids(start, end)
+	if !ok {
+		return nil
+	}
+	return c.pathsNames(c.FindMultiplePathsWithOptions(st, e, maxPaths, opts))
+}
+
+// FindFastestPaths is FindFastestPathsWithOptions on the shared graph.
+func (s *SyncGraph) FindFastestPaths(start, end string, numTrains int, opts PathOptions) ([][]string, int) {
+	c, st, e, ok := s.ids(start, end)
+	if !ok {
+		return nil, 0
+	}
+	paths, turns := c.FindFastestPathsWithOptions(st, e, numTrains, opts)
+	return c.pathsNames(paths), turns
+}
+
+// KShortestPaths is KShortestPaths on the shared graph.
+func (s *SyncGraph) KShortestPaths(start, end string, k int) [][]string {
+	c, st, e, ok := s.ids(start, end)
+	if !ok {
+		return nil
+	}
+	return c.pathsNames(c.KShortestPaths(st, e, k))
+}
+
+// AssignToPipelines is Graph.AssignToPipelines on the shared graph.
+func (s *SyncGraph) AssignToPipelines(paths [][]string, numTrains int) []*Train {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.graph.AssignToPipelines(paths, numTrains)
+}
+
+// Simulate is Graph.Simulate on the shared graph. The graph is read-locked
+// for the whole run.
+func (s *SyncGraph) Simulate(trains []*Train) *Schedule {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.graph.Simulate(trains)
+}
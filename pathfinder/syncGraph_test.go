@@ -0,0 +1,89 @@
+package pathfinder
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestQueriesDoNotChangeGraph(t *testing.T) {
+	for _, tc := range scheduleCases {
+		t.Run(tc.mapFile, func(t *testing.T) {
+			g, err := ParseMapFile("../testdata/" + tc.mapFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			before, err := ParseMapFile("../testdata/" + tc.mapFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			first := FindMultiplePaths(g, tc.start, tc.end, tc.trains)
+			for range 3 {
+				FindFastestPaths(g, tc.start, tc.end, tc.trains)
+				FindMultiplePathsWithOptions(g, tc.start, tc.end, tc.trains, PathOptions{Disjoint: EdgeDisjoint, Geometric: true})
+				KShortestPaths(g, tc.start, tc.end, 4)
+				paths, _ := FindFastestPaths(g, tc.start, tc.end, tc.trains)
+				g.Simulate(g.AssignToPipelines(paths, tc.trains))
+			}
+			if again := FindMultiplePaths(g, tc.start, tc.end, tc.trains); !reflect.DeepEqual(again, first) {
+				t.Errorf("repeated query gave %v, first %v", again, first)
+			}
+			if !reflect.DeepEqual(g, before) {
+				t.Error("queries changed the graph")
+			}
+		})
+	}
+}
+
+func TestSyncGraphConcurrentQueries(t *testing.T) {
+	g, err := ParseMapFile("../testdata/London.map")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, wantTurns := FindFastestPaths(g, "waterloo", "st_pancras", 4)
+	shared := NewSyncGraph(g)
+
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 50 {
+				paths, turns := shared.FindFastestPaths("waterloo", "st_pancras", 4, PathOptions{})
+				if !reflect.DeepEqual(paths, want) || turns != wantTurns {
+					t.Errorf("got %v in %d turns, want %v in %d", paths, turns, want, wantTurns)
+					return
+				}
+				if s := shared.Simulate(shared.AssignToPipelines(paths, 4)); s.TotalTurns != turns {
+					t.Errorf("simulated %d turns, predicted %d", s.TotalTurns, turns)
+					return
+				}
+				shared.FindMultiplePaths("waterloo", "st_pancras", 4, PathOptions{})
+				shared.KShortestPaths("waterloo", "st_pancras", 3)
+			}
+		}()
+	}
+	// Updates that leave the routes as they are, racing the queries
+	for range 20 {
+		shared.Update(func(g *Graph) {
+			g.Stations["waterloo"].Capacity = 1
+		})
+	}
+	wg.Wait()
+
+	if shared.Compiled() != shared.Compiled() {
+		t.Error("compiled graph is not cached")
+	}
+	before := shared.Compiled()
+	shared.Update(func(g *Graph) {
+		g.Connections["waterloo"] = append(g.Connections["waterloo"], "st_pancras")
+		g.Connections["st_pancras"] = append(g.Connections["st_pancras"], "waterloo")
+	})
+	if shared.Compiled() == before {
+		t.Error("Update did not drop the compiled graph")
+	}
+	if paths := shared.KShortestPaths("waterloo", "st_pancras", 1); len(paths) != 1 || len(paths[0]) != 2 {
+		t.Errorf("query after Update gave %v, want the new direct connection", paths)
+	}
+}
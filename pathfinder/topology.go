@@ -0,0 +1,298 @@
+package pathfinder
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// ---- Topology ----
+
+// Topology is the shape of the network GenerateMap builds. Every topology
+// gives a connected map: stations left apart are joined to the nearest
+// station already reached, or without crossings for PlanarTopology.
+type Topology int
+
+const (
+	// RandomTopology is a random spanning tree with random connections added
+	// until the average degree is reached.
+	RandomTopology Topology = iota
+	// GridTopology places stations on a square grid, each connected to the
+	// stations beside, above and below it. Degree is ignored.
+	GridTopology
+	// GeometricTopology connects stations closer than a radius chosen for
+	// the average degree, so connections are short and local.
+	GeometricTopology
+	// SmallWorldTopology is a ring of stations, ordered by angle around the
+	// centre of the map, each connected to its nearest ring neighbours, with
+	// one connection in ten moved to a random station.
+	SmallWorldTopology
+	// ScaleFreeTopology adds stations one at a time, each connected to
+	// stations chosen in proportion to their connections, which grows hubs.
+	ScaleFreeTopology
+	// PlanarTopology connects each station to its Degree nearest stations,
+	// or to those within GenerateOptions.Radius, shortest first, leaving
+	// out connections that would cross, like a real rail network.
+	PlanarTopology
+)
+
+// ParseTopology reads a topology as used on the command line: "random",
+// "grid", "geometric", "small-world", "scale-free" or "planar".
+func ParseTopology(s string) (Topology, error) {
+	for t := RandomTopology; t <= PlanarTopology; t++ {
+		if t.String() == s {
+			return t, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown topology %q. Use random, grid, geometric, small-world, scale-free or planar", s)
+}
+
+func (t Topology) String() string {
+	switch t {
+	case GridTopology:
+		return "grid"
+	case GeometricTopology:
+		return "geometric"
+	case SmallWorldTopology:
+		return "small-world"
+	case ScaleFreeTopology:
+		return "scale-free"
+	case PlanarTopology:
+		return "planar"
+	}
+	return "random"
+}
+
+// smallWorldRewire is the chance that a ring connection is moved.
+const smallWorldRewire = 0.1
+
+// edgeSet collects unique connections, as pairs of station indexes, in the
+// order they are added.
+type edgeSet struct {
+	pairs  [][2]int
+	exists map[[2]int]bool
+}
+
+func newEdgeSet(capacity int) *edgeSet {
+	return &edgeSet{make([][2]int, 0, capacity), make(map[[2]int]bool, capacity)}
+}
+
+func (s *edgeSet) has(a, b int) bool {
+	return s.exists[[2]int{a, b}] || s.exists[[2]int{b, a}]
+}
+
+func (s *edgeSet) add(a, b int) bool {
+	if a == b || s.has(a, b) {
+		return false
+	}
+	s.exists[[2]int{a, b}] = true
+	s.pairs = append(s.pairs, [2]int{a, b})
+	return true
+}
+
+// generateConnections builds the connections of a topology over stations at
+// coords, as pairs of indexes, and joins whatever is left apart.
+func generateConnections(rng *rand.Rand, topology Topology, coords [][2]int, degree, radius int) [][2]int {
+	n := len(coords)
+	edges := newEdgeSet(n * degree / 2)
+	switch topology {
+	case GridTopology:
+		gridConnections(edges, n)
+	case GeometricTopology:
+		geometricConnections(edges, coords, degree)
+	case SmallWorldTopology:
+		smallWorldConnections(rng, edges, coords, degree)
+	case ScaleFreeTopology:
+		scaleFreeConnections(rng, edges, n, degree)
+	case PlanarTopology:
+		// Joins its own groups without crossings
+		planarConnections(edges, coords, degree, radius)
+	default:
+		randomConnections(rng, edges, n, n*degree/2)
+	}
+	connectComponents(edges, coords)
+	return edges.pairs
+}
+
+// randomConnections links every station to a random earlier one, which
+// makes a spanning tree, then adds random connections up to target.
+func randomConnections(rng *rand.Rand, edges *edgeSet, n, target int) {
+	order := rng.Perm(n)
+	for i := 1; i < n; i++ {
+		edges.add(order[i], order[rng.Intn(i)])
+	}
+
+	// Limit the number of attempts to avoid infinite loop
+	target = min(target, n*(n-1)/2)
+	for attempts := target * 10; len(edges.pairs) < target && attempts > 0; attempts-- {
+		edges.add(rng.Intn(n), rng.Intn(n))
+	}
+}
+
+// gridSize returns the number of columns of a grid of n stations.
+func gridSize(n int) int {
+	return int(math.Ceil(math.Sqrt(float64(n))))
+}
+
+// gridCoordinates lays n stations out row by row, spacing apart.
+func gridCoordinates(n, spacing int) [][2]int {
+	cols := gridSize(n)
+	coords := make([][2]int, n)
+	for i := range coords {
+		coords[i] = [2]int{i % cols * spacing, i / cols * spacing}
+	}
+	return coords
+}
+
+func gridConnections(edges *edgeSet, n int) {
+	cols := gridSize(n)
+	for i := 0; i < n; i++ {
+		if (i+1)%cols != 0 && i+1 < n {
+			edges.add(i, i+1)
+		}
+		if i+cols < n {
+			edges.add(i, i+cols)
+		}
+	}
+}
+
+// geometricConnections connects every pair of stations within the radius
+// where a station has degree neighbours on average.
+func geometricConnections(edges *edgeSet, coords [][2]int, degree int) {
+	n := len(coords)
+	minX, minY, maxX, maxY := bounds(coords)
+	area := float64(max(maxX-minX, 1)) * float64(max(maxY-minY, 1))
+	radius := math.Sqrt(float64(degree) * area / (math.Pi * float64(n)))
+	for a := 0; a < n; a++ {
+		for b := a + 1; b < n; b++ {
+			if distance(coords[a], coords[b]) <= radius {
+				edges.add(a, b)
+			}
+		}
+	}
+}
+
+// smallWorldConnections is the Watts-Strogatz model on a ring sorted by
+// angle, so that ring neighbours are also close on the map.
+func smallWorldConnections(rng *rand.Rand, edges *edgeSet, coords [][2]int, degree int) {
+	n := len(coords)
+	minX, minY, maxX, maxY := bounds(coords)
+	cx, cy := float64(minX+maxX)/2, float64(minY+maxY)/2
+	ring := make([]int, n)
+	angles := make([]float64, n)
+	for i, c := range coords {
+		ring[i] = i
+		angles[i] = math.Atan2(float64(c[1])-cy, float64(c[0])-cx)
+	}
+	sort.Slice(ring, func(i, j int) bool { return angles[ring[i]] < angles[ring[j]] })
+
+	reach := max(degree/2, 1)
+	for i := range ring {
+		for k := 1; k <= reach && k < n; k++ {
+			a, b := ring[i], ring[(i+k)%n]
+			if rng.Float64() < smallWorldRewire {
+				// Try a shortcut instead; keep the ring link if it is taken
+				if c := rng.Intn(n); edges.add(a, c) {
+					continue
+				}
+			}
+			edges.add(a, b)
+		}
+	}
+}
+
+// scaleFreeConnections is the Barabási-Albert model: each new station is
+// connected to degree/2 stations picked in proportion to their degree.
+func scaleFreeConnections(rng *rand.Rand, edges *edgeSet, n, degree int) {
+	m := max(degree/2, 1)
+	// Every connection puts both stations in ends once, so a uniform pick
+	// from ends favours well connected stations
+	var ends []int
+	seed := min(m+1, n)
+	for a := 0; a < seed; a++ {
+		for b := a + 1; b < seed; b++ {
+			edges.add(a, b)
+			ends = append(ends, a, b)
+		}
+	}
+	for i := seed; i < n; i++ {
+		for linked := 0; linked < min(m, i); {
+			if t := ends[rng.Intn(len(ends))]; edges.add(i, t) {
+				ends = append(ends, i, t)
+				linked++
+			}
+		}
+	}
+}
+
+// connectComponents joins every group of stations that cannot reach station
+// 0 by its shortest connection to the stations that can.
+func connectComponents(edges *edgeSet, coords [][2]int) {
+	component := components(edges, len(coords))
+	var groups [][]int
+	for s, id := range component {
+		if id == len(groups) {
+			groups = append(groups, nil)
+		}
+		groups[id] = append(groups[id], s)
+	}
+
+	reached := groups[0]
+	for _, group := range groups[1:] {
+		bestA, bestB, best := -1, -1, math.Inf(1)
+		for _, a := range group {
+			for _, b := range reached {
+				if d := distance(coords[a], coords[b]); d < best {
+					bestA, bestB, best = a, b, d
+				}
+			}
+		}
+		edges.add(bestA, bestB)
+		reached = append(reached, group...)
+	}
+}
+
+// components returns a group number for each station.
+func components(edges *edgeSet, n int) []int {
+	neighbors := make([][]int, n)
+	for _, p := range edges.pairs {
+		neighbors[p[0]] = append(neighbors[p[0]], p[1])
+		neighbors[p[1]] = append(neighbors[p[1]], p[0])
+	}
+	component := make([]int, n)
+	for i := range component {
+		component[i] = -1
+	}
+	for s, id := 0, 0; s < n; s++ {
+		if component[s] != -1 {
+			continue
+		}
+		component[s] = id
+		queue := []int{s}
+		for len(queue) > 0 {
+			for _, nbr := range neighbors[queue[0]] {
+				if component[nbr] == -1 {
+					component[nbr] = id
+					queue = append(queue, nbr)
+				}
+			}
+			queue = queue[1:]
+		}
+		id++
+	}
+	return component
+}
+
+func bounds(coords [][2]int) (minX, minY, maxX, maxY int) {
+	minX, minY, maxX, maxY = coords[0][0], coords[0][1], coords[0][0], coords[0][1]
+	for _, c := range coords[1:] {
+		minX, maxX = min(minX, c[0]), max(maxX, c[0])
+		minY, maxY = min(minY, c[1]), max(maxY, c[1])
+	}
+	return minX, minY, maxX, maxY
+}
+
+func distance(a, b [2]int) float64 {
+	return math.Hypot(float64(a[0]-b[0]), float64(b[1]-a[1]))
+}
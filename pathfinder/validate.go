@@ -0,0 +1,230 @@
+package pathfinder
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ---- Violations ----
+
+// Rule names the movement rule a schedule breaks.
+type Rule string
+
+const (
+	RuleUnknownTrain    Rule = "unknown-train"
+	RuleNoConnection    Rule = "no-connection"
+	RuleWrongOrigin     Rule = "wrong-origin"
+	RuleMovedTwice      Rule = "moved-twice"
+	RuleMovedAfterEnd   Rule = "moved-after-arrival"
+	RuleStationOccupied Rule = "station-occupied"
+	RuleEdgeOccupied    Rule = "edge-occupied"
+	RuleHeadOn          Rule = "head-on"
+	RuleBlockReserved   Rule = "block-reserved"
+	RuleNotArrived      Rule = "not-arrived"
+)
+
+// Violation is one broken rule. Turn is 0 for problems with the schedule
+// as a whole, such as a train that never arrives.
+type Violation struct {
+	Turn    int
+	Train   string
+	Rule    Rule
+	Message string
+}
+
+func (v Violation) Error() string {
+	if v.Turn == 0 {
+		return fmt.Sprintf("%s: %s", v.Rule, v.Message)
+	}
+	return fmt.Sprintf("turn %d: %s: %s", v.Turn, v.Rule, v.Message)
+}
+
+// ---- Validation ----
+
+// ValidateSchedule checks a schedule against the graph independently of the
+// simulator. Trains T1..TnumTrains start at start and must all reach end.
+// Intermediate stations may hold as many trains as their capacity.
+// A move listed in turn N over a connection of weight W occupies that
+// connection from turn N-W+1 to N; trains in opposite directions share a
+// two-way connection but not a pair of one-way ones. Every broken rule is
+// reported, in turn order; nil means the schedule is valid.
+func ValidateSchedule(graph *Graph, start, end string, numTrains int, schedule *Schedule) []Violation {
+	return ValidateScheduleWithPolicy(graph, start, end, numTrains, schedule, ConflictPolicy{})
+}
+
+// ValidateScheduleWithPolicy is ValidateSchedule with the track rule given
+// by policy. Trains entering a two-way track while a train coming the other
+// way holds it are reported as head-on under every policy.
+func ValidateScheduleWithPolicy(graph *Graph, start, end string, numTrains int, schedule *Schedule, policy ConflictPolicy) []Violation {
+	var violations []Violation
+	report := func(turn int, train string, rule Rule, format string, args ...any) {
+		violations = append(violations, Violation{turn, train, rule, fmt.Sprintf(format, args...)})
+	}
+
+	type hop struct {
+		train          string
+		depart, arrive int
+		from, to       string
+		freeAt         int // first turn the track is free again
+	}
+	names := make([]string, numTrains)
+	position := make(map[string]string, numTrains)
+	freeAt := make(map[string]int, numTrains)
+	hops := make(map[string][]hop, numTrains)
+	edges := make(map[string][]hop)
+	lastTurn := 0
+
+	for i := range names {
+		names[i] = fmt.Sprintf("T%d", i+1)
+		position[names[i]] = start
+		freeAt[names[i]] = 1
+	}
+
+	for _, turn := range schedule.Turns {
+		lastTurn = max(lastTurn, turn.Number)
+		for _, move := range turn.Moves {
+			at, ok := position[move.Train]
+			if !ok {
+				report(turn.Number, move.Train, RuleUnknownTrain, "train %s is not one of T1..T%d", move.Train, numTrains)
+				continue
+			}
+			weight := graph.Weight(at, move.To)
+			h := hop{move.Train, turn.Number - weight + 1, turn.Number, at, move.To, 0}
+			h.freeAt = h.depart + policy.hold(weight)
+			if h.depart < freeAt[move.Train] {
+				report(turn.Number, move.Train, RuleMovedTwice, "train %s leaves %q on turn %d before its previous move ends", move.Train, at, h.depart)
+				continue
+			}
+			if at == end {
+				report(turn.Number, move.Train, RuleMovedAfterEnd, "train %s moves after reaching %q", move.Train, end)
+				continue
+			}
+			if move.From != "" && move.From != at {
+				report(turn.Number, move.Train, RuleWrongOrigin, "train %s is at %q, not %q", move.Train, at, move.From)
+			}
+			if !slices.Contains(graph.Connections[at], move.To) {
+				report(turn.Number, move.Train, RuleNoConnection, "train %s moves %q -> %q without a connection", move.Train, at, move.To)
+			}
+
+			edgeKey := graph.trackKey(at, move.To)
+			for _, other := range edges[edgeKey] {
+				if h.depart >= other.freeAt || other.depart >= h.freeAt {
+					continue
+				}
+				opposite := other.from != at
+				if !opposite && policy.Mode == DirectionalLock && h.depart != other.depart {
+					continue // following on the same track
+				}
+				switch policy.rule(opposite) {
+				case RuleHeadOn:
+					report(turn.Number, move.Train, RuleHeadOn, "trains %s and %s meet head-on between %q and %q", other.train, move.Train, at, move.To)
+				case RuleBlockReserved:
+					report(turn.Number, move.Train, RuleBlockReserved, "train %s enters %q-%q while it is reserved for %s", move.Train, at, move.To, other.train)
+				default:
+					report(turn.Number, move.Train, RuleEdgeOccupied, "trains %s and %s are on %q-%q at the same time", other.train, move.Train, at, move.To)
+				}
+				break
+			}
+			edges[edgeKey] = append(edges[edgeKey], h)
+			hops[move.Train] = append(hops[move.Train], h)
+			position[move.Train] = move.To
+			freeAt[move.Train] = turn.Number + 1
+		}
+	}
+
+	// Stations are checked at the end of every turn, once all moves are known
+	for turn := 1; turn <= lastTurn; turn++ {
+		occupants := make(map[string][]string)
+		for _, name := range names {
+			at := start
+			for _, h := range hops[name] {
+				if h.arrive <= turn {
+					at = h.to
+					continue
+				}
+				if h.depart <= turn {
+					at = "" // under way
+				}
+				break
+			}
+			if at != "" && at != start && at != end {
+				occupants[at] = append(occupants[at], name)
+			}
+		}
+		stations := make([]string, 0, len(occupants))
+		for station := range occupants {
+			stations = append(stations, station)
+		}
+		slices.Sort(stations)
+		for _, station := range stations {
+			if trains := occupants[station]; len(trains) > graph.Capacity(station) {
+				report(turn, trains[graph.Capacity(station)], RuleStationOccupied, "trains %s are all at %q, which holds %d", strings.Join(trains, ", "), station, graph.Capacity(station))
+			}
+		}
+	}
+
+	for _, name := range names {
+		if position[name] != end {
+			report(0, name, RuleNotArrived, "train %s ends at %q instead of %q", name, position[name], end)
+		}
+	}
+
+	// Turn order, schedule-wide problems last
+	slices.SortStableFunc(violations, func(a, b Violation) int {
+		if a.Turn == 0 || b.Turn == 0 {
+			return b.Turn - a.Turn
+		}
+		return a.Turn - b.Turn
+	})
+	return violations
+}
+
+// ---- Text Schedules ----
+
+var ansiCodes = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+
+// ParseSchedule reads a movement log in the console format
+// ("Turn N: T1-x T2-y"). Colour codes and lines that are not turns,
+// such as the path list, are ignored. From is left empty on every move.
+func ParseSchedule(r io.Reader) (*Schedule, error) {
+	schedule := &Schedule{}
+	scanner := bufio.NewScanner(r)
+	countStrings := 0
+
+	for scanner.Scan() {
+		countStrings++
+		line := strings.TrimSpace(ansiCodes.ReplaceAllString(scanner.Text(), ""))
+		if !strings.HasPrefix(line, "Turn ") {
+			continue
+		}
+		label, rest, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid turn format: %q\nString number in the schedule: %d", line, countStrings)
+		}
+		number, err := strconv.Atoi(strings.TrimPrefix(label, "Turn "))
+		if err != nil {
+			return nil, fmt.Errorf("invalid turn number: %q\nString number in the schedule: %d", label, countStrings)
+		}
+
+		turn := Turn{Number: number}
+		for _, token := range strings.Fields(rest) {
+			train, to, ok := strings.Cut(token, "-")
+			if !ok || train == "" || to == "" {
+				return nil, fmt.Errorf("invalid move format: %q\nString number in the schedule: %d", token, countStrings)
+			}
+			turn.Moves = append(turn.Moves, Move{Train: train, To: to})
+		}
+		schedule.Turns = append(schedule.Turns, turn)
+		schedule.TotalMoves += len(turn.Moves)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	schedule.TotalTurns = len(schedule.Turns)
+	return schedule, nil
+}
@@ -0,0 +1,219 @@
+package pathfinder
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// Maps from testdata with the route from their "#go run" header.
+var scheduleCases = []struct {
+	mapFile    string
+	start, end string
+	trains     int
+}{
+	{"small.map", "small", "large", 9},
+	{"London.map", "waterloo", "st_pancras", 4},
+	{"jungle.map", "jungle", "desert", 10},
+	{"beginning.map", "beginning", "terminus", 20},
+	{"beethoven.map", "beethoven", "part", 9},
+	{"bond_square.map", "bond_square", "space_port", 4},
+	{"one.map", "two", "four", 4},
+	{"weighted.map", "depot", "harbour", 6},
+	{"interchange.map", "west", "east", 8},
+	{"oneway.map", "depot", "harbour", 4},
+}
+
+func TestSimulatedSchedulesAreValid(t *testing.T) {
+	for _, tc := range scheduleCases {
+		t.Run(tc.mapFile, func(t *testing.T) {
+			graph, err := ParseMapFile("../testdata/" + tc.mapFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			paths, turns := FindFastestPaths(graph, tc.start, tc.end, tc.trains)
+			schedule := graph.Simulate(graph.AssignToPipelines(paths, tc.trains))
+
+			for _, v := range ValidateSchedule(graph, tc.start, tc.end, tc.trains, schedule) {
+				t.Error(v)
+			}
+			if schedule.TotalTurns != turns {
+				t.Errorf("predicted %d turns, simulated %d", turns, schedule.TotalTurns)
+			}
+
+			// The console text must validate the same way
+			var buf bytes.Buffer
+			if err := RenderSchedule(&buf, schedule); err != nil {
+				t.Fatal(err)
+			}
+			parsed, err := ParseSchedule(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, v := range ValidateSchedule(graph, tc.start, tc.end, tc.trains, parsed) {
+				t.Error("text:", v)
+			}
+		})
+	}
+}
+
+func TestValidateScheduleReportsEveryRule(t *testing.T) {
+	graph, err := ParseMapFile("../testdata/London.map")
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := strings.Join([]string{
+		"Turn 1: T1-euston T2-euston T3-st_pancras",
+		"Turn 2: T1-st_pancras T2-st_pancras T2-waterloo",
+	}, "\n")
+	schedule, err := ParseSchedule(strings.NewReader(log))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(map[Rule]int)
+	for _, v := range ValidateSchedule(graph, "waterloo", "st_pancras", 3, schedule) {
+		got[v.Rule]++
+	}
+	want := map[Rule]int{
+		RuleNoConnection:    1, // T3 waterloo -> st_pancras
+		RuleEdgeOccupied:    2, // T2 on waterloo-euston, then euston-st_pancras
+		RuleStationOccupied: 1, // T1 and T2 at euston
+		RuleMovedTwice:      1, // T2 in turn 2
+		RuleNotArrived:      0,
+	}
+	for rule, n := range want {
+		if got[rule] != n {
+			t.Errorf("%s: got %d violations, want %d (all: %v)", rule, got[rule], n, got)
+		}
+	}
+
+	schedule.Turns = schedule.Turns[:1]
+	notArrived := 0
+	for _, v := range ValidateSchedule(graph, "waterloo", "st_pancras", 3, schedule) {
+		if v.Rule == RuleNotArrived {
+			notArrived++
+		}
+	}
+	if notArrived != 2 {
+		t.Errorf("expected T1 and T2 to be reported as not arrived, got %d", notArrived)
+	}
+}
+
+func TestOneWayTrackPairs(t *testing.T) {
+	// T1 and T2 pass each other between b and c on turn 2
+	schedule := &Schedule{Turns: []Turn{
+		{Number: 1, Moves: []Move{{Train: "T1", To: "b"}, {Train: "T2", To: "c"}}},
+		{Number: 2, Moves: []Move{{Train: "T1", To: "c"}, {Train: "T2", To: "b"}}},
+	}}
+	for _, tc := range []struct {
+		tracks   string
+		conflict bool
+	}{
+		{"b-c", true},
+		{"b>c\nc>b", false},
+	} {
+		graph, err := ParseMap(strings.NewReader("stations:\na,0,0\nb,1,0\nc,1,1\n\nconnections:\na-b\na-c\n" + tc.tracks + "\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		conflict := false
+		for _, v := range ValidateSchedule(graph, "a", "x", 2, schedule) {
+			conflict = conflict || v.Rule == RuleHeadOn
+		}
+		if conflict != tc.conflict {
+			t.Errorf("%q: edge conflict %v, want %v", tc.tracks, conflict, tc.conflict)
+		}
+
+		c := Compile(graph)
+		b, _ := c.ID("b")
+		cc, _ := c.ID("c")
+		if shared := c.edgeIndex(b, cc) == c.edgeIndex(cc, b); shared != tc.conflict {
+			t.Errorf("%q: directions share a track %v, want %v", tc.tracks, shared, tc.conflict)
+		}
+	}
+}
+
+func TestConflictPolicies(t *testing.T) {
+	policies := []ConflictPolicy{{Mode: EdgeLock}, {Mode: DirectionalLock}, {Mode: BlockReservation, Reserve: 3}}
+	for _, tc := range scheduleCases {
+		graph, err := ParseMapFile("../testdata/" + tc.mapFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		paths, _ := FindFastestPaths(graph, tc.start, tc.end, tc.trains)
+		turns := make(map[ConflictMode]int)
+		for _, policy := range policies {
+			schedule := SimulateWithPolicy(graph, graph.AssignToPipelines(paths, tc.trains), policy)
+			for _, v := range ValidateScheduleWithPolicy(graph, tc.start, tc.end, tc.trains, schedule, policy) {
+				t.Errorf("%s %s: %v", tc.mapFile, policy, v)
+			}
+			turns[policy.Mode] = schedule.TotalTurns
+		}
+		if turns[DirectionalLock] > turns[EdgeLock] || turns[BlockReservation] < turns[EdgeLock] {
+			t.Errorf("%s: turns %v, want directional <= edge <= block", tc.mapFile, turns)
+		}
+	}
+
+	// A schedule made under edge locks breaks a longer reservation
+	graph, err := ParseMapFile("../testdata/London.map")
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths, _ := FindFastestPaths(graph, "waterloo", "st_pancras", 4)
+	schedule := graph.Simulate(graph.AssignToPipelines(paths, 4))
+	reserved := 0
+	for _, v := range ValidateScheduleWithPolicy(graph, "waterloo", "st_pancras", 4, schedule, ConflictPolicy{Mode: BlockReservation, Reserve: 2}) {
+		if v.Rule == RuleBlockReserved {
+			reserved++
+		}
+	}
+	if reserved == 0 {
+		t.Error("expected block-reserved violations")
+	}
+
+	block := SimulateWithPolicy(graph, graph.AssignToPipelines(paths, 4), ConflictPolicy{Mode: BlockReservation, Reserve: 2})
+	if len(block.Conflicts) == 0 {
+		t.Error("expected the simulator to report the trains it held back")
+	}
+	for _, v := range block.Conflicts {
+		if v.Rule != RuleBlockReserved {
+			t.Errorf("unexpected conflict %v", v)
+		}
+	}
+}
+
+func TestParseConflictPolicy(t *testing.T) {
+	for _, s := range []string{"edge", "directional", "block", "block:4"} {
+		policy, err := ParseConflictPolicy(s)
+		if err != nil {
+			t.Errorf("%q: %v", s, err)
+		} else if policy.String() != s {
+			t.Errorf("%q parsed as %q", s, policy)
+		}
+	}
+	for _, s := range []string{"", "lock", "block:0", "edge:2"} {
+		if _, err := ParseConflictPolicy(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
+
+func TestBlockReservationOnSharedStations(t *testing.T) {
+	g, err := ParseMap(strings.NewReader(crossingWeightedMap))
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := KShortestPaths(g, "s0", "s1", 4)
+	for _, policy := range []ConflictPolicy{{Mode: BlockReservation}, {Mode: BlockReservation, Reserve: 3}} {
+		for trains := 1; trains <= 8; trains++ {
+			schedule := SimulateWithPolicy(g, g.AssignToPipelines(paths, trains), policy)
+			if schedule.Arrived != trains {
+				t.Errorf("%s: %d of %d trains arrived", policy, schedule.Arrived, trains)
+			}
+			for _, v := range ValidateScheduleWithPolicy(g, "s0", "s1", trains, schedule, policy) {
+				t.Errorf("%s, %d trains: %v", policy, trains, v)
+			}
+		}
+	}
+}
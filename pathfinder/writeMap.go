@@ -0,0 +1,115 @@
+package pathfinder
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// connectionKey is the canonical "a-b" form of a connection, with the
+// stations in lexical order like normalizeEdgeKey.
+func connectionKey(a, b string) string {
+	if a < b {
+		return a + "-" + b
+	}
+	return b + "-" + a
+}
+
+// directedEdgeKey is the "a>b" form of a one-way connection from a to b.
+// It keys Graph.OneWay, and Weights and Comments for one-way connections.
+func directedEdgeKey(a, b string) string {
+	return a + ">" + b
+}
+
+// WriteOptions changes how WriteMapWithOptions lays out a map.
+type WriteOptions struct {
+	// KeepOrder writes stations and connections in the order they had in
+	// the parsed file instead of sorting them. Graphs that were not parsed,
+	// or gained stations or connections since, are always sorted.
+	KeepOrder bool
+}
+
+// WriteMap writes the graph as canonical .map text. Stations are sorted by
+// name, every connection is written once as "a-b" with a < b, or "a>b" for
+// a one-way connection, in order, and
+// comments kept in Graph.Comments are put back next to their element.
+// Parsing the output gives back the same graph.
+func WriteMap(w io.Writer, g *Graph) error {
+	return WriteMapWithOptions(w, g, WriteOptions{})
+}
+
+// WriteMapWithOptions is WriteMap with layout options.
+func WriteMapWithOptions(w io.Writer, g *Graph, opts WriteOptions) error {
+	bw := bufio.NewWriter(w)
+	writeComment := func(key, line string) {
+		c := g.Comments[key]
+		for _, above := range c.Above {
+			fmt.Fprintln(bw, above)
+		}
+		if line == "" {
+			return
+		}
+		if c.Inline != "" {
+			line += " " + c.Inline
+		}
+		fmt.Fprintln(bw, line)
+	}
+
+	names := make([]string, 0, len(g.Stations))
+	for name := range g.Stations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var connections []string
+	for _, name := range names {
+		nbrs := make([]string, 0, len(g.Connections[name]))
+		for _, nbr := range g.Connections[name] {
+			if name < nbr || g.IsOneWay(name, nbr) {
+				nbrs = append(nbrs, nbr)
+			}
+		}
+		sort.Strings(nbrs)
+		for _, nbr := range nbrs {
+			if g.IsOneWay(name, nbr) {
+				connections = append(connections, directedEdgeKey(name, nbr))
+			} else {
+				connections = append(connections, connectionKey(name, nbr))
+			}
+		}
+	}
+
+	if opts.KeepOrder && len(g.stationOrder) == len(names) && len(g.connectionOrder) == len(connections) {
+		names = g.stationOrder
+		connections = g.connectionOrder
+	}
+
+	writeComment("stations:", "stations:")
+	for _, name := range names {
+		s := g.Stations[name]
+		line := fmt.Sprintf("%s,%d,%d", s.Name, s.X, s.Y)
+		if s.Capacity > 1 {
+			line += fmt.Sprintf(",%d", s.Capacity)
+		}
+		writeComment(name, line)
+	}
+
+	fmt.Fprintln(bw)
+	writeComment("connections:", "connections:")
+	for _, key := range connections {
+		line := key
+		weightKey := key
+		if a, b, twoWay := strings.Cut(key, "-"); twoWay {
+			weightKey = normalizeEdgeKey(a, b)
+		}
+		if w, ok := g.Weights[weightKey]; ok {
+			line += fmt.Sprintf(",%d", w)
+		}
+		writeComment(key, line)
+	}
+	writeComment("", "")
+
+	return bw.Flush()
+}